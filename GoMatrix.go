@@ -1,6 +1,9 @@
 package GoMatrix
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"golang.org/x/net/netutil"
 	"log"
 	"net"
@@ -8,7 +11,9 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
+	"time"
 )
 
 // HandlerFunc定义使用的请求处理程序，替换成上下文
@@ -33,14 +38,52 @@ type Engine struct {
 	// 池优化
 	pool sync.Pool
 
+	// 框架内部日志与运行模式
+	logger LevelLogger
+	mode   string
+
 	// https支持
-	isSsl bool
-	crt   string
-	key   string
+	isSsl     bool
+	crt       string
+	key       string
+	tlsConfig *tls.Config
+
+	// 受信任的代理，用于解析客户端IP、协议与host
+	trustedProxies []*net.IPNet
+	// 解析客户端IP的请求头，为nil时只使用X-Forwarded-For
+	remoteIPHeaders []string
+
+	// HTTP/2配置，为空时使用net/http的默认行为
+	http2 *HTTP2Options
 
 	// 模板
 	htmlTemplates *template.Template
 	funcMap       template.FuncMap
+
+	// 运行中的http服务，以及每个服务上仍未关闭的连接（包括被劫持的连接），用于优雅关闭
+	serverMu sync.Mutex
+	servers  map[*http.Server]map[net.Conn]struct{}
+	// RunListener等方法共用的服务
+	listenerServer *http.Server
+	// 开始优雅关闭后置为1，就绪检查随之失败
+	shuttingDown int32
+	// 开始关闭到停止接收新连接之间的等待时间
+	shutdownDelay time.Duration
+}
+
+// 优雅关闭超时后被强制断开的连接数
+
+type ShutdownError struct {
+	Cut int
+	Err error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("GoMatrix: shutdown forced, %d connections cut: %v", e.Cut, e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
 }
 
 // 初始化引擎
@@ -48,7 +91,9 @@ type Engine struct {
 func New() *Engine {
 	engine := &Engine{
 		router: newRouter(),
+		mode:   modeFromEnv(),
 	}
+	engine.SetLogger(defaultLogger{})
 	engine.RouterGroup = &RouterGroup{engine: engine}
 	engine.groups = []*RouterGroup{engine.RouterGroup}
 	engine.pool.New = func() interface{} {
@@ -65,7 +110,9 @@ func SslNew(crt, key string) *Engine {
 		isSsl:  true,
 		crt:    crt,
 		key:    key,
+		mode:   modeFromEnv(),
 	}
+	engine.SetLogger(defaultLogger{})
 	engine.RouterGroup = &RouterGroup{engine: engine}
 	engine.groups = []*RouterGroup{engine.RouterGroup}
 	engine.pool.New = func() interface{} {
@@ -88,40 +135,42 @@ func (engine *Engine) allocateContext() *Context {
 	return &Context{engine: engine, index: -1}
 }
 
-func (group *RouterGroup) GET(pattern string, handler HandlerFunc) {
-	group.addRoute(http.MethodGet, pattern, handler)
+// 注册路由时可以在处理函数之前传入只作用于该路由的中间件
+
+func (group *RouterGroup) GET(pattern string, handlers ...HandlerFunc) {
+	group.addRoute(http.MethodGet, pattern, handlers)
 }
 
-func (group *RouterGroup) POST(pattern string, handler HandlerFunc) {
-	group.addRoute(http.MethodPost, pattern, handler)
+func (group *RouterGroup) POST(pattern string, handlers ...HandlerFunc) {
+	group.addRoute(http.MethodPost, pattern, handlers)
 }
 
-func (group *RouterGroup) PUT(pattern string, handler HandlerFunc) {
-	group.addRoute(http.MethodPut, pattern, handler)
+func (group *RouterGroup) PUT(pattern string, handlers ...HandlerFunc) {
+	group.addRoute(http.MethodPut, pattern, handlers)
 }
 
-func (group *RouterGroup) DELETE(pattern string, handler HandlerFunc) {
-	group.addRoute(http.MethodDelete, pattern, handler)
+func (group *RouterGroup) DELETE(pattern string, handlers ...HandlerFunc) {
+	group.addRoute(http.MethodDelete, pattern, handlers)
 }
 
-func (group *RouterGroup) PATCH(pattern string, handler HandlerFunc) {
-	group.addRoute(http.MethodPatch, pattern, handler)
+func (group *RouterGroup) PATCH(pattern string, handlers ...HandlerFunc) {
+	group.addRoute(http.MethodPatch, pattern, handlers)
 }
 
-func (group *RouterGroup) CONNECT(pattern string, handler HandlerFunc) {
-	group.addRoute(http.MethodConnect, pattern, handler)
+func (group *RouterGroup) CONNECT(pattern string, handlers ...HandlerFunc) {
+	group.addRoute(http.MethodConnect, pattern, handlers)
 }
 
-func (group *RouterGroup) OPTIONS(pattern string, handler HandlerFunc) {
-	group.addRoute(http.MethodOptions, pattern, handler)
+func (group *RouterGroup) OPTIONS(pattern string, handlers ...HandlerFunc) {
+	group.addRoute(http.MethodOptions, pattern, handlers)
 }
 
-func (group *RouterGroup) TRACE(pattern string, handler HandlerFunc) {
-	group.addRoute(http.MethodTrace, pattern, handler)
+func (group *RouterGroup) TRACE(pattern string, handlers ...HandlerFunc) {
+	group.addRoute(http.MethodTrace, pattern, handlers)
 }
 
-func (group *RouterGroup) HEAD(pattern string, handler HandlerFunc) {
-	group.addRoute(http.MethodHead, pattern, handler)
+func (group *RouterGroup) HEAD(pattern string, handlers ...HandlerFunc) {
+	group.addRoute(http.MethodHead, pattern, handlers)
 }
 
 func (group *RouterGroup) createStaticHandler(relativePath string, fs http.FileSystem) HandlerFunc {
@@ -147,27 +196,204 @@ func (engine *Engine) SetFuncMap(funcMap template.FuncMap) {
 	engine.funcMap = funcMap
 }
 
+// 框架内置的模板函数，SetFuncMap中的同名函数会覆盖它们
+
+func defaultFuncMap() template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": CSRFToken,
+		"csrfField": csrfFieldFunc,
+		"cspNonce":  CSPNonce,
+	}
+}
+
 func (engine *Engine) LoadHTMLGlob(pattern string) {
-	engine.htmlTemplates = template.Must(template.New("").Funcs(engine.funcMap).ParseGlob(pattern))
+	engine.htmlTemplates = template.Must(template.New("").Funcs(defaultFuncMap()).Funcs(engine.funcMap).ParseGlob(pattern))
 }
 
 func (engine *Engine) Run(serverIp, serverPort string, maxConn int) (err error) {
+	// 可以在多个goroutine中分别调用以同时监听多个地址
+	engine.serverMu.Lock()
 	engine.serverIp = serverIp
 	engine.serverPort = serverPort
+	engine.serverMu.Unlock()
+	engine.logger.Infof("start server on host %s port:%s ,workers:%d", serverIp, serverPort, maxConn)
+	return engine.RunServer(&http.Server{Addr: serverIp + ":" + serverPort}, maxConn)
+}
+
+// 使用自定义的http.Server启动，可以配置读写超时、空闲超时、MaxHeaderBytes等，
+// 最大连接数依旧通过LimitListener限制，maxConn小于等于0时不限制
+
+func (engine *Engine) RunServer(srv *http.Server, maxConn int) (err error) {
+	engine.serverMu.Lock()
 	engine.maxConn = maxConn
-	var listener net.Listener
-	log.Printf("start server on host %s port:%s ,workers:%d\n", engine.serverIp, engine.serverPort, engine.maxConn)
-	listener, err = net.Listen("tcp", engine.serverIp+":"+engine.serverPort)
+	engine.serverMu.Unlock()
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+		if engine.isSsl {
+			addr = ":https"
+		}
+	}
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
+	engine.trackServer(srv)
+	return engine.serve(srv, listener, maxConn)
+}
+
+// 所有Run方法最终都在这里启动服务，每个监听器单独限制最大连接数
+
+func (engine *Engine) serve(srv *http.Server, listener net.Listener, maxConn int) error {
 	defer listener.Close()
-	listener = netutil.LimitListener(listener, engine.maxConn)
+	engine.serverMu.Lock()
+	listener = &trackingListener{Listener: listener, engine: engine, conns: engine.servers[srv]}
+	engine.serverMu.Unlock()
+	if maxConn > 0 {
+		listener = netutil.LimitListener(listener, maxConn)
+	}
 	if engine.isSsl {
-		return http.ServeTLS(listener, engine, engine.crt, engine.key)
-	} else {
-		return http.Serve(listener, engine)
+		return srv.ServeTLS(listener, engine.crt, engine.key)
+	}
+	return srv.Serve(listener)
+}
+
+// 记录服务，serve中的trackingListener记录服务上的连接，强制关闭时用于统计被切断的连接
+
+func (engine *Engine) trackServer(srv *http.Server) {
+	engine.serverMu.Lock()
+	defer engine.serverMu.Unlock()
+	engine.trackServerLocked(srv)
+}
+
+func (engine *Engine) trackServerLocked(srv *http.Server) {
+	if _, ok := engine.servers[srv]; ok {
+		return
+	}
+	if srv.Handler == nil {
+		srv.Handler = engine
+	}
+	if srv.ErrorLog == nil {
+		srv.ErrorLog = log.New(serverErrorWriter{engine: engine}, "", 0)
 	}
+	if engine.tlsConfig != nil && srv.TLSConfig == nil {
+		srv.TLSConfig = engine.tlsConfig
+	}
+	if err := engine.configureHTTP2(srv); err != nil {
+		engine.logger.Errorf("configure http2 failed: %v", err)
+	}
+	if engine.servers == nil {
+		engine.servers = make(map[*http.Server]map[net.Conn]struct{})
+	}
+	engine.servers[srv] = make(map[net.Conn]struct{})
+}
+
+// RunListener、RunUnix等方法使用的http服务，这些监听器共享同一个服务；
+// Run与RunServer的服务单独记录，Shutdown会关闭所有服务
+
+func (engine *Engine) currentServer() *http.Server {
+	engine.serverMu.Lock()
+	defer engine.serverMu.Unlock()
+	if engine.listenerServer == nil {
+		engine.listenerServer = &http.Server{}
+		engine.trackServerLocked(engine.listenerServer)
+	}
+	return engine.listenerServer
+}
+
+// 优雅关闭：停止接收新连接并等待处理中的请求完成，ctx到期后强制断开剩余连接，
+// 并以*ShutdownError返回被断开的连接数
+
+func (engine *Engine) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&engine.shuttingDown, 1)
+	if engine.shutdownDelay > 0 {
+		// 等待负载均衡通过就绪检查摘除实例，期间仍正常处理请求
+		select {
+		case <-time.After(engine.shutdownDelay):
+		case <-ctx.Done():
+		}
+	}
+	engine.serverMu.Lock()
+	servers := make([]*http.Server, 0, len(engine.servers))
+	for srv := range engine.servers {
+		servers = append(servers, srv)
+	}
+	engine.serverMu.Unlock()
+
+	errs := make([]error, len(servers))
+	cuts := make([]int, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv *http.Server) {
+			defer wg.Done()
+			cuts[i], errs[i] = engine.shutdownServer(ctx, srv)
+		}(i, srv)
+	}
+	wg.Wait()
+
+	var err error
+	cut := 0
+	for i := range servers {
+		if err == nil {
+			err = errs[i]
+		}
+		cut += cuts[i]
+	}
+	if cut > 0 {
+		return &ShutdownError{Cut: cut, Err: err}
+	}
+	return err
+}
+
+const shutdownPollInterval = 50 * time.Millisecond
+
+// http.Server.Shutdown不会等待被劫持的连接（例如WebSocket），普通连接处理完后继续等待它们自行关闭，
+// ctx到期后与剩余的普通连接一起强制关闭。WebSocket处理函数可以通过srv.RegisterOnShutdown得知服务正在关闭
+
+func (engine *Engine) shutdownServer(ctx context.Context, srv *http.Server) (int, error) {
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		ticker := time.NewTicker(shutdownPollInterval)
+		defer ticker.Stop()
+		for err == nil && engine.openConns(srv) > 0 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-ticker.C:
+			}
+		}
+		if err == nil {
+			return 0, nil
+		}
+	}
+	engine.serverMu.Lock()
+	conns := make([]net.Conn, 0, len(engine.servers[srv]))
+	for conn := range engine.servers[srv] {
+		conns = append(conns, conn)
+	}
+	engine.serverMu.Unlock()
+	srv.Close()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns), err
+}
+
+func (engine *Engine) openConns(srv *http.Server) int {
+	engine.serverMu.Lock()
+	defer engine.serverMu.Unlock()
+	return len(engine.servers[srv])
+}
+
+// 设置Shutdown开始后继续接收请求的时间，配合Health中的就绪检查使用
+
+func (engine *Engine) SetShutdownDelay(d time.Duration) {
+	engine.shutdownDelay = d
+}
+
+func (engine *Engine) isShuttingDown() bool {
+	return atomic.LoadInt32(&engine.shuttingDown) == 1
 }
 
 // 根据分组不同挂载不同的中间件
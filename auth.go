@@ -0,0 +1,129 @@
+package GoMatrix
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 鉴权通过后的用户（主体）在上下文中的key
+const AuthUserKey = "AuthUser"
+
+// 用户名与密码
+
+type Accounts map[string]string
+
+// 基于账号表的Basic认证，通过后用户名保存在AuthUserKey中
+
+func BasicAuth(accounts Accounts, realm ...string) HandlerFunc {
+	hashed := make(map[string][32]byte, len(accounts))
+	for user, password := range accounts {
+		hashed[user] = sha256.Sum256([]byte(password))
+	}
+	// 用户不存在时也做一次比较，避免通过耗时判断用户是否存在
+	dummy := sha256.Sum256([]byte("GoMatrix"))
+	return BasicAuthWithVerifier(func(user, password string) bool {
+		expected, ok := hashed[user]
+		if !ok {
+			expected = dummy
+		}
+		actual := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(actual[:], expected[:]) == 1 && ok
+	}, realm...)
+}
+
+// 使用自定义校验函数的Basic认证
+
+func BasicAuthWithVerifier(verify func(user, password string) bool, realm ...string) HandlerFunc {
+	r := "Authorization Required"
+	if len(realm) > 0 && realm[0] != "" {
+		r = realm[0]
+	}
+	challenge := "Basic realm=" + strconv.Quote(r)
+	return func(c *Context) {
+		user, password, ok := c.Req.BasicAuth()
+		if !ok || !verify(user, password) {
+			c.SetHeader("WWW-Authenticate", challenge)
+			c.Fail(http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		c.Set(AuthUserKey, user)
+		c.Next()
+	}
+}
+
+type APIKeyConfig struct {
+	// 依次从请求头、query参数、cookie中查找，至少配置一个
+	Header string
+	Query  string
+	Cookie string
+	// 校验key，返回对应的主体
+	Validator func(key string) (principal interface{}, ok bool)
+}
+
+// API key认证，通过后Validator返回的主体保存在AuthUserKey中
+
+func APIKey(conf APIKeyConfig) HandlerFunc {
+	assert1(conf.Header != "" || conf.Query != "" || conf.Cookie != "", "api key lookup must not be empty")
+	assert1(conf.Validator != nil, "api key validator can not be nil")
+	return func(c *Context) {
+		key := lookupAPIKey(c, conf)
+		if key == "" {
+			c.Fail(http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		principal, ok := conf.Validator(key)
+		if !ok {
+			c.Fail(http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		c.Set(AuthUserKey, principal)
+		c.Next()
+	}
+}
+
+func lookupAPIKey(c *Context, conf APIKeyConfig) string {
+	if conf.Header != "" {
+		if key := c.GetHeader(conf.Header); key != "" {
+			return key
+		}
+	}
+	if conf.Query != "" {
+		if key := c.Query(conf.Query); key != "" {
+			return key
+		}
+	}
+	if conf.Cookie != "" {
+		if cookie, err := c.Req.Cookie(conf.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// 固定的key列表，使用常量时间比较
+
+func StaticAPIKeys(keys ...string) func(key string) (interface{}, bool) {
+	hashed := make([][32]byte, len(keys))
+	for i, k := range keys {
+		hashed[i] = sha256.Sum256([]byte(k))
+	}
+	return func(key string) (interface{}, bool) {
+		actual := sha256.Sum256([]byte(key))
+		found := 0
+		for i := range hashed {
+			found |= subtle.ConstantTimeCompare(actual[:], hashed[i][:])
+		}
+		return key, found == 1
+	}
+}
+
+func bearerToken(c *Context) string {
+	auth := c.GetHeader("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package GoMatrix
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+)
+
+var ErrBodyTooLarge = errors.New("http: request body too large")
+
+// 与net/http中FormValue使用的内存上限一致
+const defaultMultipartMemory = 32 << 20
+
+type BodyLimitConfig struct {
+	// 请求体的最大字节数
+	Limit int64
+	// 超出限制时的处理，默认返回413
+	ErrorHandler func(c *Context, err error)
+}
+
+// 限制请求体大小，可以挂载在分组上，也可以只作用于单个路由。
+// Content-Length超出时直接拒绝；分块传输等没有Content-Length的请求在读取超出时拒绝
+
+func BodyLimit(conf BodyLimitConfig) HandlerFunc {
+	assert1(conf.Limit > 0, "body limit must be positive")
+	if conf.ErrorHandler == nil {
+		conf.ErrorHandler = func(c *Context, err error) {
+			c.Fail(http.StatusRequestEntityTooLarge, err.Error())
+		}
+	}
+	return func(c *Context) {
+		if c.Req.ContentLength > conf.Limit {
+			rejectBody(c, conf)
+			return
+		}
+		if c.Req.Body == nil || c.Req.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		body := &limitedBody{ReadCloser: c.Req.Body, limit: conf.Limit}
+		c.Req.Body = body
+		// PostForm会忽略解析错误，表单在这里提前解析，超出限制时才能返回413
+		if isFormRequest(c.Req) {
+			c.Req.ParseMultipartForm(defaultMultipartMemory)
+			if body.exceeded {
+				rejectBody(c, conf)
+				return
+			}
+		}
+		c.Next()
+		// 处理函数读取时超出限制，且还没有写入响应
+		if body.exceeded && !c.writermem.Written() {
+			rejectBody(c, conf)
+		}
+	}
+}
+
+func rejectBody(c *Context, conf BodyLimitConfig) {
+	// 剩余的请求体不再读取，响应后关闭连接
+	c.SetHeader("Connection", "close")
+	c.Error(ErrBodyTooLarge)
+	c.Abort()
+	conf.ErrorHandler(c, ErrBodyTooLarge)
+}
+
+// 记录是否因为超出限制而读取失败，Go 1.19之前MaxBytesReader的错误没有单独的类型
+
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+// 最多读取limit+1个字节，确实读到第limit+1个字节才算超出限制，
+// 其它读取错误（客户端断开、超时等）原样返回
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+	if remaining := b.limit - b.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		b.exceeded = true
+		return n - int(b.read-b.limit), ErrBodyTooLarge
+	}
+	return n, err
+}
+
+func isFormRequest(req *http.Request) bool {
+	ctype, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return ctype == "application/x-www-form-urlencoded" || ctype == "multipart/form-data"
+}
@@ -0,0 +1,286 @@
+package GoMatrix
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	// 正常放行，统计失败
+	StateClosed BreakerState = iota
+	// 熔断，直接拒绝
+	StateOpen
+	// 放行少量探测请求，成功则恢复，失败则重新熔断
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type CircuitBreakerConfig struct {
+	// 连续失败达到该次数时熔断
+	ConsecutiveFailures int
+	// 统计窗口内失败比例达到该值时熔断，请求数不少于MinRequests时才计算，
+	// 两个条件都不设置时默认连续失败5次熔断
+	FailureRatio float64
+	MinRequests  int
+	// 关闭状态下的统计窗口，默认10秒
+	Window time.Duration
+	// 熔断持续时间，之后进入半开状态，默认30秒
+	OpenTimeout time.Duration
+	// 半开状态下放行的探测请求数，全部成功后恢复，默认1
+	HalfOpenRequests int
+	// 判断请求是否失败，默认状态码大于等于500或者c.Errors不为空
+	IsFailure func(c *Context) bool
+	// 熔断时的处理，默认返回503
+	Handler func(c *Context)
+	// 状态变化的回调，例如记录日志或指标；回调在锁内执行，不能调用State
+	OnStateChange func(from, to BreakerState)
+}
+
+// 熔断器，通常挂载在依赖同一个下游的路由分组上
+
+type CircuitBreaker struct {
+	conf CircuitBreakerConfig
+
+	mu    sync.Mutex
+	state BreakerState
+	// 每次状态变化或者窗口滚动时递增，旧请求的结果不再计入
+	generation  uint64
+	expiry      time.Time
+	requests    int
+	failures    int
+	consecutive int
+	// 半开状态下的探测请求数与成功数
+	probes    int
+	successes int
+}
+
+func NewCircuitBreaker(conf CircuitBreakerConfig) *CircuitBreaker {
+	if conf.ConsecutiveFailures <= 0 && conf.FailureRatio <= 0 {
+		conf.ConsecutiveFailures = 5
+	}
+	if conf.MinRequests <= 0 {
+		conf.MinRequests = 20
+	}
+	if conf.Window <= 0 {
+		conf.Window = 10 * time.Second
+	}
+	if conf.OpenTimeout <= 0 {
+		conf.OpenTimeout = 30 * time.Second
+	}
+	if conf.HalfOpenRequests <= 0 {
+		conf.HalfOpenRequests = 1
+	}
+	if conf.IsFailure == nil {
+		conf.IsFailure = func(c *Context) bool {
+			return c.writermem.status >= http.StatusInternalServerError || len(c.Errors) > 0
+		}
+	}
+	if conf.Handler == nil {
+		conf.Handler = func(c *Context) {
+			c.Fail(http.StatusServiceUnavailable, "Service Unavailable")
+		}
+	}
+	cb := &CircuitBreaker{conf: conf}
+	cb.toState(StateClosed, time.Now())
+	return cb
+}
+
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.currentState(time.Now())
+}
+
+func (cb *CircuitBreaker) Middleware() HandlerFunc {
+	return func(c *Context) {
+		generation, retryAfter, ok := cb.allow()
+		if !ok {
+			if retryAfter > 0 {
+				c.SetHeader("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+			}
+			c.Abort()
+			cb.conf.Handler(c)
+			return
+		}
+		// 处理函数panic时同样计为失败
+		failed := true
+		defer func() {
+			cb.done(generation, failed)
+		}()
+		c.Next()
+		failed = cb.conf.IsFailure(c)
+	}
+}
+
+func (cb *CircuitBreaker) allow() (uint64, time.Duration, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := time.Now()
+	switch cb.currentState(now) {
+	case StateOpen:
+		return cb.generation, cb.expiry.Sub(now), false
+	case StateHalfOpen:
+		if cb.probes >= cb.conf.HalfOpenRequests {
+			return cb.generation, 0, false
+		}
+		cb.probes++
+	}
+	cb.requests++
+	return cb.generation, 0, true
+}
+
+func (cb *CircuitBreaker) done(generation uint64, failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := time.Now()
+	state := cb.currentState(now)
+	if generation != cb.generation {
+		return
+	}
+	switch state {
+	case StateClosed:
+		if !failed {
+			cb.consecutive = 0
+			return
+		}
+		cb.failures++
+		cb.consecutive++
+		if cb.shouldTrip() {
+			cb.toState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		if failed {
+			cb.toState(StateOpen, now)
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.conf.HalfOpenRequests {
+			cb.toState(StateClosed, now)
+		}
+	}
+}
+
+func (cb *CircuitBreaker) shouldTrip() bool {
+	if cb.conf.ConsecutiveFailures > 0 && cb.consecutive >= cb.conf.ConsecutiveFailures {
+		return true
+	}
+	return cb.conf.FailureRatio > 0 && cb.requests >= cb.conf.MinRequests &&
+		float64(cb.failures)/float64(cb.requests) >= cb.conf.FailureRatio
+}
+
+// 根据时间推进状态：熔断到期进入半开，关闭状态的统计窗口到期后清零
+func (cb *CircuitBreaker) currentState(now time.Time) BreakerState {
+	switch cb.state {
+	case StateClosed:
+		if now.After(cb.expiry) {
+			cb.resetCounts(now)
+		}
+	case StateOpen:
+		if now.After(cb.expiry) {
+			cb.toState(StateHalfOpen, now)
+		}
+	}
+	return cb.state
+}
+
+func (cb *CircuitBreaker) resetCounts(now time.Time) {
+	cb.generation++
+	cb.requests, cb.failures, cb.consecutive = 0, 0, 0
+	cb.probes, cb.successes = 0, 0
+	cb.expiry = now.Add(cb.conf.Window)
+}
+
+func (cb *CircuitBreaker) toState(state BreakerState, now time.Time) {
+	prev := cb.state
+	cb.state = state
+	cb.resetCounts(now)
+	switch state {
+	case StateOpen:
+		cb.expiry = now.Add(cb.conf.OpenTimeout)
+	case StateHalfOpen:
+		cb.expiry = time.Time{}
+	}
+	if prev != state && cb.conf.OnStateChange != nil {
+		cb.conf.OnStateChange(prev, state)
+	}
+}
+
+type BulkheadConfig struct {
+	// 同时处理的最大请求数
+	MaxConcurrent int
+	// 最多排队等待的请求数，0表示不排队
+	MaxWait int
+	// 排队的最长时间，默认1秒
+	WaitTimeout time.Duration
+	// 被拒绝时的处理，默认返回503
+	Handler func(c *Context)
+}
+
+// 舱壁隔离：限制一个分组内同时处理的请求数，避免单个慢依赖占满所有连接。
+// Run中的maxConn限制的是整个服务的连接数，舱壁限制的是分组内的并发请求数
+
+func Bulkhead(conf BulkheadConfig) HandlerFunc {
+	assert1(conf.MaxConcurrent > 0, "bulkhead max concurrent must be positive")
+	if conf.WaitTimeout <= 0 {
+		conf.WaitTimeout = time.Second
+	}
+	if conf.Handler == nil {
+		conf.Handler = func(c *Context) {
+			c.Fail(http.StatusServiceUnavailable, "Service Unavailable")
+		}
+	}
+	sem := make(chan struct{}, conf.MaxConcurrent)
+	var mu sync.Mutex
+	waiting := 0
+	reject := func(c *Context) {
+		c.Abort()
+		conf.Handler(c)
+	}
+	return func(c *Context) {
+		select {
+		case sem <- struct{}{}:
+		default:
+			mu.Lock()
+			if waiting >= conf.MaxWait {
+				mu.Unlock()
+				reject(c)
+				return
+			}
+			waiting++
+			mu.Unlock()
+			timer := time.NewTimer(conf.WaitTimeout)
+			acquired := false
+			select {
+			case sem <- struct{}{}:
+				acquired = true
+			case <-timer.C:
+			case <-c.Req.Context().Done():
+			}
+			timer.Stop()
+			mu.Lock()
+			waiting--
+			mu.Unlock()
+			if !acquired {
+				reject(c)
+				return
+			}
+		}
+		defer func() { <-sem }()
+		c.Next()
+	}
+}
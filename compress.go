@@ -0,0 +1,316 @@
+package GoMatrix
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 压缩写入器，gzip.Writer、flate.Writer以及常见的brotli、zstd实现都满足该接口
+
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// 压缩算法，实现该接口即可接入brotli、zstd等
+
+type Encoder interface {
+	// Content-Encoding中的名称
+	Encoding() string
+	NewWriter(w io.Writer, level int) (CompressWriter, error)
+}
+
+type GzipEncoder struct{}
+
+func (GzipEncoder) Encoding() string { return "gzip" }
+
+func (GzipEncoder) NewWriter(w io.Writer, level int) (CompressWriter, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+type DeflateEncoder struct{}
+
+func (DeflateEncoder) Encoding() string { return "deflate" }
+
+func (DeflateEncoder) NewWriter(w io.Writer, level int) (CompressWriter, error) {
+	return flate.NewWriter(w, level)
+}
+
+type CompressConfig struct {
+	// 压缩级别，0表示各算法的默认级别
+	Level int
+	// 响应小于该字节数时不压缩，默认1024
+	MinLength int
+	// 支持的算法，客户端权重相同时按顺序优先，默认gzip、deflate
+	Encoders []Encoder
+	// 不压缩的Content-Type前缀，默认排除图片、音视频与压缩包等已经压缩过的类型
+	ExcludedContentTypes []string
+}
+
+var defaultExcludedContentTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+	"video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/x-7z-compressed", "application/x-rar-compressed",
+	"application/zstd", "application/x-bzip2", "application/pdf",
+}
+
+const defaultCompressMinLength = 1024
+
+// 响应压缩中间件
+
+func Compress(config ...CompressConfig) HandlerFunc {
+	var conf CompressConfig
+	if len(config) > 0 {
+		conf = config[0]
+	}
+	if conf.Level == 0 {
+		conf.Level = flate.DefaultCompression
+	}
+	if conf.MinLength <= 0 {
+		conf.MinLength = defaultCompressMinLength
+	}
+	if len(conf.Encoders) == 0 {
+		conf.Encoders = []Encoder{GzipEncoder{}, DeflateEncoder{}}
+	}
+	if conf.ExcludedContentTypes == nil {
+		conf.ExcludedContentTypes = defaultExcludedContentTypes
+	}
+	// 每种算法一个写入器池
+	pools := make(map[string]*sync.Pool, len(conf.Encoders))
+	for _, enc := range conf.Encoders {
+		enc := enc
+		pools[enc.Encoding()] = &sync.Pool{New: func() interface{} {
+			w, err := enc.NewWriter(io.Discard, conf.Level)
+			if err != nil {
+				return nil
+			}
+			return w
+		}}
+	}
+
+	return func(c *Context) {
+		// 协议升级（WebSocket等）不经过压缩
+		if headerContainsToken(c.Req.Header, "Connection", "upgrade") {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		// 压缩后的内容无法按原始字节范围返回
+		if c.GetHeader("Range") != "" || c.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		enc := negotiateEncoding(c.GetHeader("Accept-Encoding"), conf.Encoders)
+		if enc == nil {
+			c.Next()
+			return
+		}
+		cw := &compressResponseWriter{
+			ResponseWriter: c.Writer,
+			encoding:       enc.Encoding(),
+			pool:           pools[enc.Encoding()],
+			conf:           &conf,
+			status:         http.StatusOK,
+		}
+		c.Writer = cw
+		defer func() {
+			cw.close()
+			c.Writer = cw.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// 按Accept-Encoding中的权重选择算法
+
+func negotiateEncoding(accept string, encoders []Encoder) Encoder {
+	if accept == "" {
+		return nil
+	}
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			name = part[:i]
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		weights[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+	var best Encoder
+	bestQ := 0.0
+	for _, enc := range encoders {
+		q, ok := weights[enc.Encoding()]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// 先缓冲响应，直到数据超过MinLength或者需要Flush时再决定是否压缩
+
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	pool     *sync.Pool
+	conf     *CompressConfig
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	compressing bool
+	buf         []byte
+	writer      CompressWriter
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	// 103 Early Hints等信息响应直接发送，之后还会有最终的状态码
+	if code >= 100 && code < http.StatusOK && code != http.StatusSwitchingProtocols {
+		if !w.decided && !w.wroteHeader {
+			w.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	if w.decided || w.wroteHeader {
+		return
+	}
+	w.status = code
+	w.wroteHeader = true
+	// 没有响应体的状态码不需要等待数据
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.wroteHeader = true
+		w.buf = append(w.buf, data...)
+		if len(w.buf) < w.conf.MinLength {
+			return len(data), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if w.compressing {
+		return w.writer.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressResponseWriter) shouldCompress(force bool) bool {
+	if w.status < http.StatusOK || w.status == http.StatusNoContent ||
+		w.status == http.StatusNotModified || w.status == http.StatusPartialContent {
+		return false
+	}
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	if !force && len(w.buf) < w.conf.MinLength {
+		return false
+	}
+	ctype := header.Get("Content-Type")
+	if ctype == "" {
+		// 在压缩前嗅探类型，否则net/http会把压缩后的数据识别为二进制
+		ctype = http.DetectContentType(w.buf)
+		header.Set("Content-Type", ctype)
+	}
+	ctype = strings.ToLower(ctype)
+	for _, excluded := range w.conf.ExcludedContentTypes {
+		if strings.HasPrefix(ctype, excluded) {
+			return false
+		}
+	}
+	return true
+}
+
+// 确定是否压缩，写出响应头与缓冲的数据
+
+func (w *compressResponseWriter) decide(force bool) error {
+	w.decided = true
+	if w.shouldCompress(force) {
+		if writer, ok := w.pool.Get().(CompressWriter); ok {
+			writer.Reset(w.ResponseWriter)
+			w.writer = writer
+			w.compressing = true
+			header := w.Header()
+			header.Del("Content-Length")
+			header.Set("Content-Encoding", w.encoding)
+			// 压缩后的字节与原始内容不同，不能再按原始内容的范围续传，
+			// 强ETag也只能标识原始内容，降为弱ETag，If-Range要求强匹配
+			header.Del("Accept-Ranges")
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				header.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.compressing {
+		_, err = w.writer.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+func (w *compressResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.compressing {
+		w.writer.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("GoMatrix: response does not implement http.Hijacker")
+	}
+	w.decided = true
+	return hijacker.Hijack()
+}
+
+func (w *compressResponseWriter) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.compressing {
+		w.writer.Close()
+		w.writer.Reset(io.Discard)
+		w.pool.Put(w.writer)
+		w.writer = nil
+		w.compressing = false
+	}
+}
@@ -0,0 +1,107 @@
+package GoMatrix
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressRepresentationHeaders(t *testing.T) {
+	body := strings.Repeat("compressible text ", 200)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "page.txt")
+	if err := os.WriteFile(file, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := New()
+	engine.SetMode(ReleaseMode)
+	engine.Use(Compress())
+	engine.GET("/etag", func(c *Context) {
+		c.SetHeader("ETag", `"v1"`)
+		c.SetHeader("Accept-Ranges", "bytes")
+		c.String(http.StatusOK, "%s", body)
+	})
+	engine.GET("/weak", func(c *Context) {
+		c.SetHeader("ETag", `W/"v1"`)
+		c.String(http.StatusOK, "%s", body)
+	})
+	engine.GET("/small", func(c *Context) {
+		c.SetHeader("ETag", `"v1"`)
+		c.SetHeader("Accept-Ranges", "bytes")
+		c.String(http.StatusOK, "small")
+	})
+	engine.GET("/file", func(c *Context) {
+		c.ServeFile(file)
+	})
+	engine.Static("/static", dir)
+
+	tests := []struct {
+		name         string
+		path         string
+		header       map[string]string
+		status       int
+		encoding     string
+		acceptRanges string
+		etag         string
+	}{
+		{"strong etag is weakened", "/etag", map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, "gzip", "", `W/"v1"`},
+		{"weak etag unchanged", "/weak", map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, "gzip", "", `W/"v1"`},
+		{"identity keeps validators", "/etag", nil, http.StatusOK, "", "bytes", `"v1"`},
+		{"small body is not compressed", "/small", map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, "", "bytes", `"v1"`},
+		{"range is served uncompressed", "/etag", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9"}, http.StatusOK, "", "bytes", `"v1"`},
+		{"serve file", "/file", map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, "gzip", "", ""},
+		{"serve file range", "/file", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9"}, http.StatusPartialContent, "", "bytes", ""},
+		{"static", "/static/page.txt", map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, "gzip", "", ""},
+		{"static range with if-range", "/static/page.txt", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9", "If-Range": `W/"v1"`}, http.StatusOK, "", "bytes", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			res := w.Result()
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+			// 无论是否压缩，响应都取决于Accept-Encoding
+			if !headerContainsToken(res.Header, "Vary", "Accept-Encoding") {
+				t.Errorf("Vary = %q, want Accept-Encoding", res.Header["Vary"])
+			}
+			if got := res.Header.Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if got := res.Header.Get("Accept-Ranges"); got != tt.acceptRanges {
+				t.Errorf("Accept-Ranges = %q, want %q", got, tt.acceptRanges)
+			}
+			if got := res.Header.Get("ETag"); got != tt.etag {
+				t.Errorf("ETag = %q, want %q", got, tt.etag)
+			}
+			if tt.encoding != "gzip" {
+				return
+			}
+			if res.Header.Get("Content-Length") != "" {
+				t.Errorf("Content-Length = %q, want none", res.Header.Get("Content-Length"))
+			}
+			zr, err := gzip.NewReader(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != body {
+				t.Errorf("decompressed body mismatch, got %d bytes", len(data))
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"sync"
 )
 
 type H map[string]interface{}
@@ -22,21 +23,82 @@ type Context struct {
 	Path   string
 	Method string
 	Params map[string]string
+	// 匹配到的路由模式，例如/p/:lang
+	fullPath string
 	// 响应信息
 	StatusCode int
 	engine     *Engine
+	writermem  responseWriter
 
 	// 中间件实现
 	index       int8
 	middlewares HandlersChain
+
+	// 请求内共享的数据，中间件与处理函数之间传递
+	mu   sync.RWMutex
+	Keys map[string]interface{}
+
+	// SetCookie使用的SameSite
+	sameSite http.SameSite
+
+	// 处理过程中记录的错误，供日志、熔断等中间件读取
+	Errors []error
 }
 
 func (c *Context) newContext(w http.ResponseWriter, req *http.Request) {
-	c.Writer = w
+	c.writermem.reset(w)
+	c.Writer = &c.writermem
 	c.Req = req
 	c.Path = req.URL.Path
 	c.Method = req.Method
+	c.Params = nil
+	c.fullPath = ""
 	c.index = -1
+	c.Keys = nil
+	c.sameSite = http.SameSiteDefaultMode
+	c.Errors = c.Errors[:0]
+}
+
+// 记录处理过程中的错误，不会中断处理链
+
+func (c *Context) Error(err error) {
+	if err != nil {
+		c.Errors = append(c.Errors, err)
+	}
+}
+
+// 在上下文中保存数据
+
+func (c *Context) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Keys == nil {
+		c.Keys = make(map[string]interface{})
+	}
+	c.Keys[key] = value
+}
+
+// 从上下文中读取数据
+
+func (c *Context) Get(key string) (value interface{}, exists bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, exists = c.Keys[key]
+	return
+}
+
+func (c *Context) MustGet(key string) interface{} {
+	if value, exists := c.Get(key); exists {
+		return value
+	}
+	panic("Key \"" + key + "\" does not exist")
+}
+
+func (c *Context) GetString(key string) (s string) {
+	if value, ok := c.Get(key); ok && value != nil {
+		s, _ = value.(string)
+	}
+	return
 }
 
 // Form参数
@@ -105,6 +167,12 @@ func (c *Context) HTML(code int, name string, data interface{}) {
 	}
 }
 
+// 匹配到的路由模式，没有匹配到路由时为空，适合用作日志与指标的标签
+
+func (c *Context) FullPath() string {
+	return c.fullPath
+}
+
 // 从上下文中读取param参数
 
 func (c *Context) Param(key string) string {
@@ -147,6 +215,40 @@ func (c *Context) GetHeader(key string) string {
 	return c.Req.Header.Get(key)
 }
 
+// 设置之后SetCookie写入的cookie的SameSite属性
+
+func (c *Context) SetSameSite(sameSite http.SameSite) {
+	c.sameSite = sameSite
+}
+
+// 写入cookie，maxAge小于0时删除cookie
+
+func (c *Context) SetCookie(name, value string, maxAge int, path, domain string, secure, httpOnly bool) {
+	if path == "" {
+		path = "/"
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    url.QueryEscape(value),
+		MaxAge:   maxAge,
+		Path:     path,
+		Domain:   domain,
+		SameSite: c.sameSite,
+		Secure:   secure,
+		HttpOnly: httpOnly,
+	})
+}
+
+// 读取cookie，不存在时返回http.ErrNoCookie
+
+func (c *Context) Cookie(name string) (string, error) {
+	cookie, err := c.Req.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return url.QueryUnescape(cookie.Value)
+}
+
 // 中间件的流转，主要通过index的移位来决定执行哪个middlewares中的中间件
 
 func (c *Context) Next() {
@@ -0,0 +1,135 @@
+package GoMatrix
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CORSConfig struct {
+	// 允许的源，支持"*"、完整的源（https://a.com）以及子域名通配（https://*.a.com）
+	AllowOrigins []string
+	// 自定义的源校验，与AllowOrigins任意一个通过即可
+	AllowOriginFunc func(origin string) bool
+	// 允许的方法，默认GET、POST、PUT、PATCH、DELETE、HEAD
+	AllowMethods []string
+	// 允许的请求头，为空时按预检请求的Access-Control-Request-Headers原样放行
+	AllowHeaders []string
+	// 允许浏览器读取的响应头
+	ExposeHeaders []string
+	// 是否允许携带cookie，不能与AllowOrigins中的"*"同时使用
+	AllowCredentials bool
+	// 预检结果的缓存时间
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodHead,
+}
+
+// 跨域中间件，预检请求直接以204返回，不再进入路由
+
+func CORS(conf CORSConfig) HandlerFunc {
+	allowAll := false
+	exact := make(map[string]struct{})
+	var wildcards [][2]string
+	for _, origin := range conf.AllowOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			allowAll = true
+		case strings.Contains(origin, "*."):
+			i := strings.Index(origin, "*.")
+			wildcards = append(wildcards, [2]string{origin[:i], origin[i+1:]})
+		default:
+			exact[origin] = struct{}{}
+		}
+	}
+	methods := conf.AllowMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(conf.AllowHeaders, ", ")
+	exposeHeaders := strings.Join(conf.ExposeHeaders, ", ")
+	maxAge := ""
+	if conf.MaxAge > 0 {
+		maxAge = strconv.FormatInt(int64(conf.MaxAge/time.Second), 10)
+	}
+	// 任意源都能携带cookie时，所有网站都可以读取用户的数据
+	assert1(!allowAll || !conf.AllowCredentials, "cors can not allow credentials for all origins")
+	// 返回"*"时响应与请求的源无关，不需要Vary: Origin
+	wildcardResponse := allowAll
+
+	allowed := func(origin string) bool {
+		if allowAll {
+			return true
+		}
+		lower := strings.ToLower(origin)
+		if _, ok := exact[lower]; ok {
+			return true
+		}
+		for _, w := range wildcards {
+			if len(lower) > len(w[0])+len(w[1]) && strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) {
+				return true
+			}
+		}
+		return conf.AllowOriginFunc != nil && conf.AllowOriginFunc(origin)
+	}
+
+	return func(c *Context) {
+		header := c.Writer.Header()
+		// 没有Origin的响应也可能被缓存后返回给跨域请求，同样需要Vary
+		if !wildcardResponse {
+			header.Add("Vary", "Origin")
+		}
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		preflight := c.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !allowed(origin) {
+			if preflight {
+				c.Status(http.StatusForbidden)
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if wildcardResponse {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if conf.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", allowMethods)
+			if allowHeaders != "" {
+				header.Set("Access-Control-Allow-Headers", allowHeaders)
+			} else if requested := c.GetHeader("Access-Control-Request-Headers"); requested != "" {
+				header.Set("Access-Control-Allow-Headers", requested)
+			}
+			if maxAge != "" {
+				header.Set("Access-Control-Max-Age", maxAge)
+			}
+			c.Status(http.StatusNoContent)
+			c.Abort()
+			return
+		}
+
+		if exposeHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", exposeHeaders)
+		}
+		c.Next()
+	}
+}
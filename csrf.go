@@ -0,0 +1,244 @@
+package GoMatrix
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+const (
+	csrfKey           = "_csrf"
+	csrfTokenLength   = 32
+	defaultCSRFCookie = "_csrf"
+	defaultCSRFField  = "_csrf"
+	defaultCSRFHeader = "X-CSRF-Token"
+)
+
+var (
+	ErrCSRFToken   = errors.New("csrf: token missing or invalid")
+	ErrCSRFOrigin  = errors.New("csrf: origin not allowed")
+	ErrCSRFReferer = errors.New("csrf: referer missing or not allowed")
+)
+
+type CSRFConfig struct {
+	// 为true时token保存在会话中（同步器模式），需要先挂载Sessions中间件；
+	// 否则使用双重提交cookie
+	UseSession bool
+	// 不为空时对cookie中的token签名
+	Codec *SecureCookie
+	// cookie名称，默认_csrf
+	CookieName string
+	// 表单字段名称，默认_csrf
+	FormField string
+	// 请求头名称，默认X-CSRF-Token
+	Header string
+	// 除同源外允许提交的来源，例如https://app.example.com
+	TrustedOrigins []string
+	// cookie属性，SameSite默认Lax
+	Path     string
+	Domain   string
+	MaxAge   int
+	Secure   bool
+	SameSite http.SameSite
+	// 校验失败的处理，默认返回403
+	ErrorHandler func(c *Context, err error)
+}
+
+// CSRF防护中间件，GET、HEAD、OPTIONS、TRACE之外的请求需要校验来源与token，
+// 模板中使用{{csrfField .ctx}}嵌入隐藏字段，或者通过CSRFToken获取token
+
+func CSRF(config ...CSRFConfig) HandlerFunc {
+	var conf CSRFConfig
+	if len(config) > 0 {
+		conf = config[0]
+	}
+	if conf.CookieName == "" {
+		conf.CookieName = defaultCSRFCookie
+	}
+	if conf.FormField == "" {
+		conf.FormField = defaultCSRFField
+	}
+	if conf.Header == "" {
+		conf.Header = defaultCSRFHeader
+	}
+	if conf.Path == "" {
+		conf.Path = "/"
+	}
+	if conf.SameSite == 0 {
+		conf.SameSite = http.SameSiteLaxMode
+	}
+	if conf.ErrorHandler == nil {
+		conf.ErrorHandler = func(c *Context, err error) {
+			c.Fail(http.StatusForbidden, err.Error())
+		}
+	}
+	trusted := make(map[string]bool, len(conf.TrustedOrigins))
+	for _, origin := range conf.TrustedOrigins {
+		trusted[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	return func(c *Context) {
+		secret := loadCSRFSecret(c, &conf)
+		if secret == nil {
+			secret = make([]byte, csrfTokenLength)
+			if _, err := rand.Read(secret); err != nil {
+				c.engine.logger.Errorf("generate csrf token failed: %v", err)
+				c.Fail(http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			if err := saveCSRFSecret(c, &conf, secret); err != nil {
+				c.engine.logger.Errorf("save csrf token failed: %v", err)
+			}
+		}
+		c.Set(csrfKey, &csrfState{secret: secret, field: conf.FormField})
+		c.Writer.Header().Add("Vary", "Cookie")
+
+		switch c.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			c.Next()
+			return
+		}
+		if err := checkCSRFOrigin(c, trusted); err != nil {
+			c.Abort()
+			conf.ErrorHandler(c, err)
+			return
+		}
+		token := c.GetHeader(conf.Header)
+		if token == "" {
+			token = c.PostForm(conf.FormField)
+		}
+		if !validCSRFToken(token, secret) {
+			c.Abort()
+			conf.ErrorHandler(c, ErrCSRFToken)
+			return
+		}
+		c.Next()
+	}
+}
+
+// 获取当前请求的CSRF token，每次调用的结果不同，防止BREACH攻击；模板中为{{csrfToken .ctx}}
+
+func CSRFToken(c *Context) string {
+	value, ok := c.Get(csrfKey)
+	if !ok {
+		return ""
+	}
+	return maskCSRFToken(value.(*csrfState).secret)
+}
+
+type csrfState struct {
+	secret []byte
+	field  string
+}
+
+func loadCSRFSecret(c *Context, conf *CSRFConfig) []byte {
+	var value string
+	if conf.UseSession {
+		value, _ = c.Session().Get(csrfKey).(string)
+	} else if cookie, err := c.Req.Cookie(conf.CookieName); err == nil {
+		value = cookie.Value
+		if conf.Codec != nil {
+			data, err := conf.Codec.Decode(conf.CookieName, value)
+			if err != nil {
+				return nil
+			}
+			value = string(data)
+		}
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(secret) != csrfTokenLength {
+		return nil
+	}
+	return secret
+}
+
+func saveCSRFSecret(c *Context, conf *CSRFConfig, secret []byte) error {
+	value := base64.RawURLEncoding.EncodeToString(secret)
+	if conf.UseSession {
+		c.Session().Set(csrfKey, value)
+		return nil
+	}
+	if conf.Codec != nil {
+		var err error
+		if value, err = conf.Codec.Encode(conf.CookieName, []byte(value)); err != nil {
+			return err
+		}
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     conf.CookieName,
+		Value:    value,
+		Path:     conf.Path,
+		Domain:   conf.Domain,
+		MaxAge:   conf.MaxAge,
+		Secure:   conf.Secure,
+		HttpOnly: true,
+		SameSite: conf.SameSite,
+	})
+	return nil
+}
+
+// 有Origin时必须同源或者受信任；HTTPS请求没有Origin时要求Referer同源，防止中间人从HTTP页面提交
+
+func checkCSRFOrigin(c *Context, trusted map[string]bool) error {
+	scheme := c.Scheme()
+	self := scheme + "://" + strings.ToLower(c.Host())
+	if origin := c.GetHeader("Origin"); origin != "" {
+		origin = strings.ToLower(origin)
+		if origin == self || trusted[origin] {
+			return nil
+		}
+		return ErrCSRFOrigin
+	}
+	if scheme != "https" {
+		return nil
+	}
+	referer, err := url.Parse(c.GetHeader("Referer"))
+	if err != nil || referer.Host == "" {
+		return ErrCSRFReferer
+	}
+	origin := strings.ToLower(referer.Scheme + "://" + referer.Host)
+	if origin == self || trusted[origin] {
+		return nil
+	}
+	return ErrCSRFReferer
+}
+
+// 输出的token为随机掩码加上token与掩码的异或
+
+func maskCSRFToken(secret []byte) string {
+	token := make([]byte, 2*csrfTokenLength)
+	mask := token[:csrfTokenLength]
+	rand.Read(mask)
+	for i := range secret {
+		token[csrfTokenLength+i] = secret[i] ^ mask[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func validCSRFToken(token string, secret []byte) bool {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) != 2*csrfTokenLength {
+		return false
+	}
+	unmasked := make([]byte, csrfTokenLength)
+	for i := range unmasked {
+		unmasked[i] = data[i] ^ data[csrfTokenLength+i]
+	}
+	return subtle.ConstantTimeCompare(unmasked, secret) == 1
+}
+
+// 模板函数：{{csrfField .ctx}}，输出隐藏的表单字段
+
+func csrfFieldFunc(c *Context) string {
+	value, ok := c.Get(csrfKey)
+	if !ok {
+		return ""
+	}
+	state := value.(*csrfState)
+	return `<input type="hidden" name="` + template.HTMLEscapeString(state.field) +
+		`" value="` + maskCSRFToken(state.secret) + `">`
+}
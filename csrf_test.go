@@ -0,0 +1,326 @@
+package GoMatrix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newCSRFEngine(conf CSRFConfig) *Engine {
+	engine := New()
+	engine.SetMode(ReleaseMode)
+	engine.Use(CSRF(conf))
+	engine.GET("/form", func(c *Context) {
+		c.String(http.StatusOK, "%s", CSRFToken(c))
+	})
+	handler := func(c *Context) {
+		c.String(http.StatusOK, "ok")
+	}
+	engine.POST("/submit", handler)
+	engine.HEAD("/submit", handler)
+	engine.OPTIONS("/submit", handler)
+	return engine
+}
+
+// 通过GET请求获取csrf cookie与token
+func fetchCSRFToken(t *testing.T, engine *Engine, target string) (*http.Cookie, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET status = %d", w.Code)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == defaultCSRFCookie {
+			return c, w.Body.String()
+		}
+	}
+	t.Fatal("missing csrf cookie")
+	return nil, ""
+}
+
+func TestCSRF(t *testing.T) {
+	engine := newCSRFEngine(CSRFConfig{TrustedOrigins: []string{"https://app.example.com/"}})
+	cookie, token := fetchCSRFToken(t, engine, "http://example.com/form")
+	_, otherToken := fetchCSRFToken(t, engine, "http://example.com/form")
+	secureCookie, secureToken := fetchCSRFToken(t, engine, "https://example.com/form")
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		cookie *http.Cookie
+		header http.Header
+		form   url.Values
+		status int
+		body   string
+	}{
+		{
+			name:   "safe method without token",
+			method: http.MethodHead,
+			target: "http://example.com/submit",
+			status: http.StatusOK,
+		},
+		{
+			name:   "options without token",
+			method: http.MethodOptions,
+			target: "http://example.com/submit",
+			header: http.Header{"Origin": {"https://evil.com"}},
+			status: http.StatusOK,
+			body:   "ok",
+		},
+		{
+			name:   "missing token",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			status: http.StatusForbidden,
+			body:   ErrCSRFToken.Error(),
+		},
+		{
+			name:   "missing cookie",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			header: http.Header{"X-Csrf-Token": {token}},
+			status: http.StatusForbidden,
+			body:   ErrCSRFToken.Error(),
+		},
+		{
+			name:   "mismatched token",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			header: http.Header{"X-Csrf-Token": {otherToken}},
+			status: http.StatusForbidden,
+			body:   ErrCSRFToken.Error(),
+		},
+		{
+			name:   "malformed token",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			header: http.Header{"X-Csrf-Token": {token[:len(token)-2]}},
+			status: http.StatusForbidden,
+			body:   ErrCSRFToken.Error(),
+		},
+		{
+			name:   "header token",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			header: http.Header{"X-Csrf-Token": {token}},
+			status: http.StatusOK,
+			body:   "ok",
+		},
+		{
+			name:   "form token",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			form:   url.Values{defaultCSRFField: {token}},
+			status: http.StatusOK,
+			body:   "ok",
+		},
+		{
+			name:   "header token takes precedence over form token",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			header: http.Header{"X-Csrf-Token": {otherToken}},
+			form:   url.Values{defaultCSRFField: {token}},
+			status: http.StatusForbidden,
+			body:   ErrCSRFToken.Error(),
+		},
+		{
+			name:   "masked tokens differ but all validate",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			form:   url.Values{defaultCSRFField: {fetchTokenWithCookie(t, engine, cookie)}},
+			status: http.StatusOK,
+			body:   "ok",
+		},
+		{
+			name:   "same origin",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			header: http.Header{"X-Csrf-Token": {token}, "Origin": {"http://Example.com"}},
+			status: http.StatusOK,
+			body:   "ok",
+		},
+		{
+			name:   "cross origin",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			header: http.Header{"X-Csrf-Token": {token}, "Origin": {"https://evil.com"}},
+			status: http.StatusForbidden,
+			body:   ErrCSRFOrigin.Error(),
+		},
+		{
+			name:   "cross scheme",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			header: http.Header{"X-Csrf-Token": {token}, "Origin": {"https://example.com"}},
+			status: http.StatusForbidden,
+			body:   ErrCSRFOrigin.Error(),
+		},
+		{
+			name:   "trusted origin",
+			method: http.MethodPost,
+			target: "http://example.com/submit",
+			cookie: cookie,
+			header: http.Header{"X-Csrf-Token": {token}, "Origin": {"https://app.example.com"}},
+			status: http.StatusOK,
+			body:   "ok",
+		},
+		{
+			name:   "https without origin or referer",
+			method: http.MethodPost,
+			target: "https://example.com/submit",
+			cookie: secureCookie,
+			header: http.Header{"X-Csrf-Token": {secureToken}},
+			status: http.StatusForbidden,
+			body:   ErrCSRFReferer.Error(),
+		},
+		{
+			name:   "https with cross origin referer",
+			method: http.MethodPost,
+			target: "https://example.com/submit",
+			cookie: secureCookie,
+			header: http.Header{"X-Csrf-Token": {secureToken}, "Referer": {"http://example.com/form"}},
+			status: http.StatusForbidden,
+			body:   ErrCSRFReferer.Error(),
+		},
+		{
+			name:   "https with same origin referer",
+			method: http.MethodPost,
+			target: "https://example.com/submit",
+			cookie: secureCookie,
+			header: http.Header{"X-Csrf-Token": {secureToken}, "Referer": {"https://example.com/form"}},
+			status: http.StatusOK,
+			body:   "ok",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.form != nil {
+				req = httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(tt.method, tt.target, nil)
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.body != "" && !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+// 用已有cookie再次获取token，掩码不同但对应同一个secret
+func fetchTokenWithCookie(t *testing.T, engine *Engine, cookie *http.Cookie) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/form", nil)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if len(w.Result().Cookies()) != 0 {
+		t.Fatal("existing csrf cookie was reissued")
+	}
+	return w.Body.String()
+}
+
+func TestCSRFSignedCookie(t *testing.T) {
+	codec := mustSecureCookie(t, CookieKey{HashKey: testHashKey})
+	engine := newCSRFEngine(CSRFConfig{Codec: codec})
+	cookie, token := fetchCSRFToken(t, engine, "http://example.com/form")
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		status int
+	}{
+		{"signed cookie", cookie, http.StatusOK},
+		{"tampered cookie", &http.Cookie{Name: cookie.Name, Value: tamperCookie(t, cookie.Value, 10)}, http.StatusForbidden},
+		// 没有签名的secret不被接受
+		{"unsigned cookie", &http.Cookie{Name: cookie.Name, Value: strings.Repeat("A", 43)}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/submit", nil)
+			req.Header.Set("X-CSRF-Token", token)
+			req.AddCookie(tt.cookie)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestCSRFSession(t *testing.T) {
+	engine := New()
+	engine.SetMode(ReleaseMode)
+	engine.Use(Sessions(SessionConfig{Codec: mustSecureCookie(t, CookieKey{HashKey: testHashKey})}))
+	engine.Use(CSRF(CSRFConfig{UseSession: true}))
+	engine.GET("/form", func(c *Context) {
+		c.String(http.StatusOK, "%s", CSRFToken(c))
+	})
+	engine.POST("/submit", func(c *Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/form", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != defaultSessionName {
+		t.Fatalf("cookies = %v, want only the session cookie", cookies)
+	}
+	token := w.Body.String()
+
+	tests := []struct {
+		name   string
+		cookie bool
+		token  string
+		status int
+	}{
+		{"valid", true, token, http.StatusOK},
+		{"missing token", true, "", http.StatusForbidden},
+		{"new session", false, token, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/submit", nil)
+			if tt.token != "" {
+				req.Header.Set("X-CSRF-Token", tt.token)
+			}
+			if tt.cookie {
+				req.AddCookie(cookies[0])
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package GoMatrix
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	runtimetrace "runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var processStart = time.Now()
+
+// 已注册的路由
+
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// 最后一个处理函数的名称
+	Handler string `json:"handler"`
+	// 注册路由时传入的处理函数个数，包含路由级别的中间件
+	Handlers int `json:"handlers"`
+}
+
+// 按路径与方法排序返回所有路由
+
+func (engine *Engine) Routes() []RouteInfo {
+	routes := make([]RouteInfo, 0, len(engine.router.handlers))
+	for key, handlers := range engine.router.handlers {
+		i := strings.IndexByte(key, '-')
+		routes = append(routes, RouteInfo{
+			Method:   key[:i],
+			Path:     key[i+1:],
+			Handler:  nameOfFunction(handlers[len(handlers)-1]),
+			Handlers: len(handlers),
+		})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+func nameOfFunction(f interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+}
+
+// 在分组下挂载调试接口，所有接口都需要先通过auth：
+//   /pprof/       pprof，包括profile、trace、heap、goroutine等
+//   /vars         命令行参数、内存与运行时统计
+//   /goroutines   所有goroutine的调用栈，?debug=1时按调用栈合并
+//   /routes       路由表
+// 这里直接使用runtime/pprof与runtime/trace，不导入net/http/pprof与expvar，
+// 它们会在http.DefaultServeMux上注册不需要认证的/debug/pprof与/debug/vars
+
+func (engine *Engine) Debug(group *RouterGroup, auth HandlerFunc) {
+	assert1(auth != nil, "debug endpoints must be protected by an auth middleware")
+	if group == nil {
+		group = engine.RouterGroup
+	}
+	group.GET("/pprof", auth, pprofIndex)
+	group.GET("/pprof/*name", auth, pprofHandler)
+	group.POST("/pprof/*name", auth, pprofHandler)
+	group.GET("/vars", auth, debugVars)
+	group.GET("/goroutines", auth, debugGoroutines)
+	group.GET("/routes", auth, func(c *Context) {
+		c.JSON(http.StatusOK, engine.Routes())
+	})
+}
+
+// 页面中的链接是相对路径，需要以/结尾
+func pprofIndex(c *Context) {
+	if !strings.HasSuffix(c.Req.URL.Path, "/") {
+		c.SetHeader("Location", c.Req.URL.Path+"/")
+		c.Status(http.StatusMovedPermanently)
+		return
+	}
+	pprofList(c)
+}
+
+func pprofHandler(c *Context) {
+	c.SetHeader("X-Content-Type-Options", "nosniff")
+	switch name := c.Param("name"); name {
+	case "":
+		pprofList(c)
+	case "cmdline":
+		c.SetHeader("Content-Type", "text/plain; charset=utf-8")
+		c.Data(http.StatusOK, []byte(strings.Join(os.Args, "\x00")))
+	case "profile":
+		pprofCPU(c)
+	case "symbol":
+		pprofSymbol(c)
+	case "trace":
+		pprofTrace(c)
+	default:
+		pprofProfile(c, name)
+	}
+}
+
+var pprofIndexTemplate = template.Must(template.New("pprof").Parse(`<html>
+<head><title>pprof</title></head>
+<body>
+<p>Set debug=1 as a query parameter to export in legacy text format</p>
+<table>
+<thead><td>Count</td><td>Profile</td></thead>
+{{range .}}<tr><td>{{.Count}}</td><td><a href="{{.Name}}?debug=1">{{.Name}}</a></td></tr>
+{{end}}</table>
+<a href="goroutine?debug=2">full goroutine stack dump</a><br>
+<a href="profile">profile</a> (CPU, ?seconds=30)<br>
+<a href="trace">trace</a> (execution trace, ?seconds=1)<br>
+<a href="cmdline">cmdline</a>
+</body>
+</html>
+`))
+
+func pprofList(c *Context) {
+	type profile struct {
+		Name  string
+		Count int
+	}
+	var profiles []profile
+	for _, p := range pprof.Profiles() {
+		profiles = append(profiles, profile{Name: p.Name(), Count: p.Count()})
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+	c.SetHeader("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	pprofIndexTemplate.Execute(c.Writer, profiles)
+}
+
+func pprofProfile(c *Context, name string) {
+	p := pprof.Lookup(name)
+	if p == nil {
+		c.Fail(http.StatusNotFound, "unknown profile: "+name)
+		return
+	}
+	if name == "heap" && c.Query("gc") != "" {
+		runtime.GC()
+	}
+	debug, _ := strconv.Atoi(c.Query("debug"))
+	if debug != 0 {
+		c.SetHeader("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		c.SetHeader("Content-Type", "application/octet-stream")
+		c.SetHeader("Content-Disposition", `attachment; filename="`+name+`"`)
+	}
+	c.Status(http.StatusOK)
+	p.WriteTo(c.Writer, debug)
+}
+
+// 采样期间按请求的context提前结束
+func pprofDuration(c *Context, def float64) time.Duration {
+	sec, err := strconv.ParseFloat(c.Query("seconds"), 64)
+	if err != nil || sec <= 0 {
+		sec = def
+	}
+	return time.Duration(sec * float64(time.Second))
+}
+
+func pprofSleep(c *Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.Req.Context().Done():
+	}
+}
+
+func pprofCPU(c *Context) {
+	d := pprofDuration(c, 30)
+	// 开始采样后就会写入响应，需要提前设置响应头
+	c.SetHeader("Content-Type", "application/octet-stream")
+	c.SetHeader("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(c.Writer); err != nil {
+		c.Writer.Header().Del("Content-Disposition")
+		c.Fail(http.StatusInternalServerError, "could not enable CPU profiling: "+err.Error())
+		return
+	}
+	pprofSleep(c, d)
+	pprof.StopCPUProfile()
+}
+
+func pprofTrace(c *Context) {
+	d := pprofDuration(c, 1)
+	c.SetHeader("Content-Type", "application/octet-stream")
+	c.SetHeader("Content-Disposition", `attachment; filename="trace"`)
+	if err := runtimetrace.Start(c.Writer); err != nil {
+		c.Writer.Header().Del("Content-Disposition")
+		c.Fail(http.StatusInternalServerError, "could not enable tracing: "+err.Error())
+		return
+	}
+	pprofSleep(c, d)
+	runtimetrace.Stop()
+}
+
+// go tool pprof用于符号化的接口，地址以+分隔，POST时从请求体中读取
+func pprofSymbol(c *Context) {
+	var buf bytes.Buffer
+	buf.WriteString("num_symbols: 1\n")
+	var r *bufio.Reader
+	if c.Method == http.MethodPost {
+		r = bufio.NewReader(c.Req.Body)
+	} else {
+		r = bufio.NewReader(strings.NewReader(c.Req.URL.RawQuery))
+	}
+	for {
+		word, err := r.ReadSlice('+')
+		if err == nil {
+			word = word[:len(word)-1]
+		}
+		if pc, _ := strconv.ParseUint(string(word), 0, 64); pc != 0 {
+			if f := runtime.FuncForPC(uintptr(pc)); f != nil {
+				fmt.Fprintf(&buf, "%#x %s\n", pc, f.Name())
+			}
+		}
+		if err != nil {
+			if err != io.EOF && err != bufio.ErrBufferFull {
+				fmt.Fprintf(&buf, "reading request: %v\n", err)
+			}
+			break
+		}
+	}
+	c.SetHeader("Content-Type", "text/plain; charset=utf-8")
+	c.Data(http.StatusOK, buf.Bytes())
+}
+
+type runtimeStats struct {
+	GoVersion    string  `json:"go_version"`
+	GOOS         string  `json:"goos"`
+	GOARCH       string  `json:"goarch"`
+	NumCPU       int     `json:"num_cpu"`
+	GOMAXPROCS   int     `json:"gomaxprocs"`
+	Goroutines   int     `json:"goroutines"`
+	CgoCalls     int64   `json:"cgo_calls"`
+	Uptime       float64 `json:"uptime_seconds"`
+	HeapAlloc    uint64  `json:"heap_alloc_bytes"`
+	HeapInuse    uint64  `json:"heap_inuse_bytes"`
+	HeapObjects  uint64  `json:"heap_objects"`
+	Sys          uint64  `json:"sys_bytes"`
+	NumGC        uint32  `json:"num_gc"`
+	PauseTotalNs uint64  `json:"gc_pause_total_ns"`
+	LastGC       string  `json:"last_gc,omitempty"`
+}
+
+func readRuntimeStats() runtimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := runtimeStats{
+		GoVersion:    runtime.Version(),
+		GOOS:         runtime.GOOS,
+		GOARCH:       runtime.GOARCH,
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		Goroutines:   runtime.NumGoroutine(),
+		CgoCalls:     runtime.NumCgoCall(),
+		Uptime:       time.Since(processStart).Seconds(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapObjects:  m.HeapObjects,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+		PauseTotalNs: m.PauseTotalNs,
+	}
+	if m.LastGC > 0 {
+		stats.LastGC = time.Unix(0, int64(m.LastGC)).Format(time.RFC3339Nano)
+	}
+	return stats
+}
+
+// 格式与expvar的/debug/vars相同
+func debugVars(c *Context) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	c.JSON(http.StatusOK, struct {
+		Cmdline  []string          `json:"cmdline"`
+		Memstats *runtime.MemStats `json:"memstats"`
+		Runtime  runtimeStats      `json:"runtime"`
+	}{os.Args, &m, readRuntimeStats()})
+}
+
+func debugGoroutines(c *Context) {
+	debug := 2
+	if c.Query("debug") == "1" {
+		debug = 1
+	}
+	c.SetHeader("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+	pprof.Lookup("goroutine").WriteTo(c.Writer, debug)
+}
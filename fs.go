@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"mime"
 	"mime/multipart"
 	"net/http"
@@ -249,7 +248,7 @@ func (c *Context) serveContent(sizeFunc func() (int64, error), content io.ReadSe
 			}()
 		}
 		c.SetHeader("Accept-Ranges", "bytes")
-		if c.GetHeader("Content-Encoding") == "" {
+		if c.Writer.Header().Get("Content-Encoding") == "" {
 			c.SetHeader("Content-Length", strconv.FormatInt(sendSize, 10))
 		}
 	}
@@ -378,15 +377,6 @@ func (c *Context) writeNotModified() {
 	c.Status(http.StatusOK)
 }
 
-func logf(r *http.Request, format string, args ...interface{}) {
-	s, _ := r.Context().Value(http.ServerContextKey).(*http.Server)
-	if s != nil && s.ErrorLog != nil {
-		s.ErrorLog.Printf(format, args...)
-	} else {
-		log.Printf(format, args...)
-	}
-}
-
 func (c *Context) dirList(f http.File) {
 	var dirs anyDirs
 	var err error
@@ -401,7 +391,7 @@ func (c *Context) dirList(f http.File) {
 	}
 
 	if err != nil {
-		logf(c.Req, "http: error reading directory: %v", err)
+		c.engine.logger.Errorf("http: error reading directory: %v", err)
 		http.Error(c.Writer, "Error reading directory", http.StatusInternalServerError)
 		return
 	}
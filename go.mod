@@ -2,4 +2,4 @@ module github.com/Salmon-x/GoMatrix
 
 go 1.16
 
-require golang.org/x/net v0.0.0-20220805013720-a33c5aa5df48 // indirect
+require golang.org/x/net v0.0.0-20220805013720-a33c5aa5df48
@@ -1,7 +1,5 @@
 package GoMatrix
 
-import "log"
-
 type RouterGroup struct {
 	// 当前分组前缀
 	prefix string
@@ -24,10 +22,10 @@ func (group *RouterGroup) Group(prefix string) *RouterGroup {
 	return newGroup
 }
 
-func (group *RouterGroup) addRoute(method string, comp string, handler HandlerFunc) {
+func (group *RouterGroup) addRoute(method string, comp string, handlers HandlersChain) {
 	pattern := group.prefix + comp
-	log.Printf("Route %4s - %s", method, pattern)
-	group.engine.router.addRoute(method, pattern, handler)
+	group.engine.logger.Debugf("Route %4s - %s", method, pattern)
+	group.engine.router.addRoute(method, pattern, handlers)
 }
 
 // 在分组上挂载中间件
@@ -0,0 +1,179 @@
+package GoMatrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCheckTimeout  = 3 * time.Second
+	defaultCheckCacheTTL = time.Second
+)
+
+var errShuttingDown = errors.New("server is shutting down")
+
+// 就绪检查项，例如数据库、缓存、下游服务的连通性
+
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+type checkerFunc struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func (c checkerFunc) Name() string {
+	return c.name
+}
+
+func (c checkerFunc) Check(ctx context.Context) error {
+	return c.fn(ctx)
+}
+
+// 使用函数创建检查项
+
+func NewChecker(name string, fn func(ctx context.Context) error) Checker {
+	return checkerFunc{name: name, fn: fn}
+}
+
+type checkerOptions struct {
+	Checker
+	timeout  time.Duration
+	cacheTTL time.Duration
+}
+
+// 为检查项设置超时时间与结果缓存时间，为0时分别使用默认的3秒与1秒，cacheTTL小于0时不缓存
+
+func CheckerWithOptions(checker Checker, timeout, cacheTTL time.Duration) Checker {
+	return checkerOptions{Checker: checker, timeout: timeout, cacheTTL: cacheTTL}
+}
+
+// 单个检查项的结果
+
+type CheckResult struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_ms"`
+	Cached   bool    `json:"cached,omitempty"`
+}
+
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+type healthCheck struct {
+	checker  Checker
+	timeout  time.Duration
+	cacheTTL time.Duration
+
+	// 同一时间只执行一次检查，并发的探测等待并复用结果
+	mu      sync.Mutex
+	result  CheckResult
+	checked time.Time
+}
+
+func (h *healthCheck) run(ctx context.Context) CheckResult {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.checked.IsZero() && time.Since(h.checked) < h.cacheTTL {
+		result := h.result
+		result.Cached = true
+		return result
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	start := time.Now()
+	// 检查项可能不理会ctx，超时后不再等待它返回
+	done := make(chan error, 1)
+	go func() {
+		// 检查项panic时记为失败，不能让探测请求拖垮进程
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("check panicked: %v", p)
+			}
+		}()
+		done <- h.checker.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := CheckResult{
+		Name:     h.checker.Name(),
+		Status:   "ok",
+		Duration: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	h.result, h.checked = result, time.Now()
+	return result
+}
+
+// 在分组上注册/livez与/readyz，group为空时注册在引擎上。
+// /livez只表示进程存活；/readyz并发执行所有检查项，任意一项失败或者服务正在关闭时返回503
+
+func (engine *Engine) Health(group *RouterGroup, checkers ...Checker) {
+	if group == nil {
+		group = engine.RouterGroup
+	}
+	checks := make([]*healthCheck, len(checkers))
+	for i, checker := range checkers {
+		check := &healthCheck{checker: checker, timeout: defaultCheckTimeout, cacheTTL: defaultCheckCacheTTL}
+		if opts, ok := checker.(checkerOptions); ok {
+			check.checker = opts.Checker
+			if opts.timeout > 0 {
+				check.timeout = opts.timeout
+			}
+			if opts.cacheTTL != 0 {
+				check.cacheTTL = opts.cacheTTL
+			}
+		}
+		checks[i] = check
+	}
+
+	group.GET("/livez", func(c *Context) {
+		c.SetHeader("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, HealthReport{Status: "ok"})
+	})
+	group.GET("/readyz", func(c *Context) {
+		c.SetHeader("Cache-Control", "no-store")
+		if engine.isShuttingDown() {
+			c.JSON(http.StatusServiceUnavailable, HealthReport{
+				Status: "fail",
+				Checks: []CheckResult{{Name: "shutdown", Status: "fail", Error: errShuttingDown.Error()}},
+			})
+			return
+		}
+		report := HealthReport{Status: "ok", Checks: make([]CheckResult, len(checks))}
+		var wg sync.WaitGroup
+		for i, check := range checks {
+			wg.Add(1)
+			go func(i int, check *healthCheck) {
+				defer wg.Done()
+				// 结果会被缓存，不使用请求的context，避免探测方断开导致缓存失败结果
+				report.Checks[i] = check.run(context.Background())
+			}(i, check)
+		}
+		wg.Wait()
+		code := http.StatusOK
+		for _, result := range report.Checks {
+			if result.Status != "ok" {
+				report.Status = "fail"
+				code = http.StatusServiceUnavailable
+			}
+		}
+		c.JSON(code, report)
+	})
+}
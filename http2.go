@@ -0,0 +1,73 @@
+package GoMatrix
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+type HTTP2Options struct {
+	// 单个连接上的最大并发流
+	MaxConcurrentStreams uint32
+	// 允许读取的最大帧大小，范围16KB~16MB
+	MaxReadFrameSize uint32
+	// 连接与单个流的接收窗口
+	MaxUploadBufferPerConnection int32
+	MaxUploadBufferPerStream     int32
+	// 连接空闲多久后关闭
+	IdleTimeout time.Duration
+
+	// 明文HTTP/2（h2c），仅在未开启TLS时生效，
+	// PriorKnowledge接受直接以HTTP/2连接前言开始的连接，Upgrade接受HTTP/1.1的Upgrade: h2c
+	PriorKnowledge bool
+	Upgrade        bool
+}
+
+// 配置HTTP/2，TLS下对协商出的h2连接生效，明文下按选项开启h2c
+
+func (engine *Engine) UseHTTP2(opts HTTP2Options) {
+	engine.http2 = &opts
+}
+
+func (engine *Engine) http2Server() *http2.Server {
+	opts := engine.http2
+	return &http2.Server{
+		MaxConcurrentStreams:         opts.MaxConcurrentStreams,
+		MaxReadFrameSize:             opts.MaxReadFrameSize,
+		MaxUploadBufferPerConnection: opts.MaxUploadBufferPerConnection,
+		MaxUploadBufferPerStream:     opts.MaxUploadBufferPerStream,
+		IdleTimeout:                  opts.IdleTimeout,
+	}
+}
+
+// 在http服务上挂载HTTP/2，ConfigureServer同时注册了关闭钩子，Shutdown时会向h2连接发送GOAWAY
+
+func (engine *Engine) configureHTTP2(srv *http.Server) error {
+	if engine.http2 == nil {
+		return nil
+	}
+	h2s := engine.http2Server()
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		return err
+	}
+	opts := engine.http2
+	if engine.isSsl || !(opts.PriorKnowledge || opts.Upgrade) {
+		return nil
+	}
+	handler := h2c.NewHandler(srv.Handler, h2s)
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PRI" && r.Proto == "HTTP/2.0" && !opts.PriorKnowledge {
+			http.Error(w, "h2c prior knowledge not supported", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		if !opts.Upgrade && headerContainsToken(r.Header, "Upgrade", "h2c") {
+			// 忽略升级请求，按HTTP/1.1处理
+			r.Header.Del("Upgrade")
+			r.Header.Del("Http2-Settings")
+		}
+		handler.ServeHTTP(w, r)
+	})
+	return nil
+}
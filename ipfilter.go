@@ -0,0 +1,174 @@
+package GoMatrix
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+type IPFilterConfig struct {
+	// 允许的地址，CIDR或单个IP，为空时除Deny外都允许
+	Allow []string
+	// 拒绝的地址，优先于Allow
+	Deny []string
+	// 被拒绝时的处理，默认返回403
+	Handler func(c *Context)
+}
+
+// IP黑白名单，规则可以在运行时通过Update整体替换
+
+type IPFilter struct {
+	rules   atomic.Value
+	handler func(c *Context)
+}
+
+type ipRules struct {
+	allow *ipTrie
+	deny  *ipTrie
+}
+
+func NewIPFilter(conf IPFilterConfig) (*IPFilter, error) {
+	f := &IPFilter{handler: conf.Handler}
+	if f.handler == nil {
+		f.handler = func(c *Context) {
+			c.Fail(http.StatusForbidden, "Forbidden")
+		}
+	}
+	if err := f.Update(conf.Allow, conf.Deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// 替换规则，解析失败时保留原有规则，正在处理的请求不受影响
+
+func (f *IPFilter) Update(allow, deny []string) error {
+	rules := &ipRules{}
+	var err error
+	if len(allow) > 0 {
+		if rules.allow, err = newIPTrie(allow); err != nil {
+			return err
+		}
+	}
+	if rules.deny, err = newIPTrie(deny); err != nil {
+		return err
+	}
+	f.rules.Store(rules)
+	return nil
+}
+
+// 判断IP是否允许访问，无法解析的地址一律拒绝
+
+func (f *IPFilter) Allowed(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	rules := f.rules.Load().(*ipRules)
+	if rules.deny.contains(parsed) {
+		return false
+	}
+	return rules.allow == nil || rules.allow.contains(parsed)
+}
+
+// 按ClientIP过滤请求的中间件
+
+func (f *IPFilter) Middleware() HandlerFunc {
+	return func(c *Context) {
+		if !f.Allowed(c.ClientIP()) {
+			c.Abort()
+			f.handler(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// 二进制前缀树，IPv4与IPv6分开存储，查找时间只与地址长度有关
+
+type ipTrie struct {
+	v4 *ipTrieNode
+	v6 *ipTrieNode
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	// 从根到该节点的前缀是一条规则
+	terminal bool
+}
+
+func newIPTrie(cidrs []string) (*ipTrie, error) {
+	t := &ipTrie{v4: &ipTrieNode{}, v6: &ipTrieNode{}}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				t.insert(ip4, 32)
+			} else {
+				t.insert(ip, 128)
+			}
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ones, bits := ipNet.Mask.Size()
+		if bits == 128 && ipNet.IP.To4() != nil {
+			// ::ffff:0:0/96之类的IPv4映射网段，换算为IPv4前缀
+			if ones < 96 {
+				ones = 96
+			}
+			ones -= 96
+		}
+		t.insert(ipNet.IP, ones)
+	}
+	return t, nil
+}
+
+func (t *ipTrie) root(ip net.IP) (*ipTrieNode, net.IP) {
+	// IPv4映射的IPv6地址按IPv4处理
+	if ip4 := ip.To4(); ip4 != nil {
+		return t.v4, ip4
+	}
+	return t.v6, ip.To16()
+}
+
+func (t *ipTrie) insert(ip net.IP, ones int) {
+	node, ip := t.root(ip)
+	for i := 0; i < ones; i++ {
+		if node.terminal {
+			// 已经被更短的前缀覆盖
+			return
+		}
+		bit := ip[i/8] >> (7 - uint(i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &ipTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+	node.children = [2]*ipTrieNode{}
+}
+
+func (t *ipTrie) contains(ip net.IP) bool {
+	if t == nil {
+		return false
+	}
+	node, ip := t.root(ip)
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == len(ip)*8 {
+			return false
+		}
+		node = node.children[ip[i/8]>>(7-uint(i%8))&1]
+	}
+	return false
+}
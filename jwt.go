@@ -0,0 +1,278 @@
+package GoMatrix
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 支持的签名算法
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	ErrJWTMissing     = errors.New("jwt: token missing")
+	ErrJWTMalformed   = errors.New("jwt: token malformed")
+	ErrJWTUnknownKey  = errors.New("jwt: unknown key")
+	ErrJWTAlgorithm   = errors.New("jwt: unexpected signing algorithm")
+	ErrJWTSignature   = errors.New("jwt: signature invalid")
+	ErrJWTExpired     = errors.New("jwt: token expired")
+	ErrJWTNotYetValid = errors.New("jwt: token not valid yet")
+	ErrJWTIssuedLater = errors.New("jwt: token issued in the future")
+	ErrJWTIssuer      = errors.New("jwt: issuer mismatch")
+	ErrJWTAudience    = errors.New("jwt: audience mismatch")
+	errJWTKeyMismatch = errors.New("jwt: key type does not match algorithm")
+)
+
+type JWTClaims map[string]interface{}
+
+// 签名密钥：HS256为[]byte，RS256为*rsa.PublicKey，ES256为*ecdsa.PublicKey（P-256）
+
+type JWTKey struct {
+	Algorithm string
+	Key       interface{}
+}
+
+type JWTConfig struct {
+	// 按token头部的kid选择密钥，轮换时新旧密钥同时保留；token没有kid时使用key为""的密钥
+	Keys map[string]JWTKey
+	// 动态获取密钥（例如从JWKS拉取），优先于Keys
+	KeyFunc func(kid string) (JWTKey, error)
+	// 不为空时校验iss与aud
+	Issuer   string
+	Audience string
+	// 校验exp、nbf、iat时允许的时钟偏差
+	ClockSkew time.Duration
+	// 额外的claims校验
+	Validate func(claims JWTClaims) error
+	// 校验失败的处理，默认返回401
+	ErrorHandler func(c *Context, err error)
+}
+
+// JWT认证，从Authorization: Bearer中读取token，通过后claims保存在AuthUserKey中
+
+func JWT(conf JWTConfig) HandlerFunc {
+	assert1(conf.Keys != nil || conf.KeyFunc != nil, "jwt Keys or KeyFunc must be set")
+	if conf.ErrorHandler == nil {
+		conf.ErrorHandler = func(c *Context, err error) {
+			c.SetHeader("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.Fail(http.StatusUnauthorized, err.Error())
+		}
+	}
+	return func(c *Context) {
+		token := bearerToken(c)
+		if token == "" {
+			c.Abort()
+			conf.ErrorHandler(c, ErrJWTMissing)
+			return
+		}
+		claims, err := ParseJWT(token, conf)
+		if err != nil {
+			c.Abort()
+			conf.ErrorHandler(c, err)
+			return
+		}
+		c.Set(AuthUserKey, claims)
+		c.Next()
+	}
+}
+
+// 校验签名与claims，返回claims
+
+func ParseJWT(token string, conf JWTConfig) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	key, err := lookupJWTKey(header.Kid, conf)
+	if err != nil {
+		return nil, err
+	}
+	// 算法以服务端配置的密钥为准，防止alg混淆攻击
+	if header.Alg != key.Algorithm {
+		return nil, ErrJWTAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if err := verifyJWTSignature(key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	var claims JWTClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if err := claims.validate(conf, time.Now()); err != nil {
+		return nil, err
+	}
+	if conf.Validate != nil {
+		if err := conf.Validate(claims); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+func decodeJWTSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func lookupJWTKey(kid string, conf JWTConfig) (JWTKey, error) {
+	if conf.KeyFunc != nil {
+		return conf.KeyFunc(kid)
+	}
+	key, ok := conf.Keys[kid]
+	if !ok {
+		return JWTKey{}, ErrJWTUnknownKey
+	}
+	return key, nil
+}
+
+func verifyJWTSignature(key JWTKey, signingInput string, signature []byte) error {
+	sum := sha256.Sum256([]byte(signingInput))
+	switch key.Algorithm {
+	case HS256:
+		secret, ok := key.Key.([]byte)
+		if !ok {
+			return errJWTKeyMismatch
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrJWTSignature
+		}
+	case RS256:
+		pub, ok := key.Key.(*rsa.PublicKey)
+		if !ok {
+			return errJWTKeyMismatch
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], signature) != nil {
+			return ErrJWTSignature
+		}
+	case ES256:
+		pub, ok := key.Key.(*ecdsa.PublicKey)
+		if !ok {
+			return errJWTKeyMismatch
+		}
+		// ES256的签名是定长的R||S
+		if len(signature) != 64 {
+			return ErrJWTSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, sum[:], r, s) {
+			return ErrJWTSignature
+		}
+	default:
+		return ErrJWTAlgorithm
+	}
+	return nil
+}
+
+// 校验时间与签发方、受众
+
+func (claims JWTClaims) validate(conf JWTConfig, now time.Time) error {
+	skew := conf.ClockSkew
+	exp, hasExp, err := claims.time("exp")
+	if err != nil {
+		return err
+	}
+	if hasExp && now.After(exp.Add(skew)) {
+		return ErrJWTExpired
+	}
+	nbf, hasNbf, err := claims.time("nbf")
+	if err != nil {
+		return err
+	}
+	if hasNbf && now.Add(skew).Before(nbf) {
+		return ErrJWTNotYetValid
+	}
+	iat, hasIat, err := claims.time("iat")
+	if err != nil {
+		return err
+	}
+	if hasIat && now.Add(skew).Before(iat) {
+		return ErrJWTIssuedLater
+	}
+	if conf.Issuer != "" && claims["iss"] != conf.Issuer {
+		return ErrJWTIssuer
+	}
+	if conf.Audience != "" && !claims.hasAudience(conf.Audience) {
+		return ErrJWTAudience
+	}
+	return nil
+}
+
+// 时间claim不存在时ok为false；存在但不是数字时视为格式错误，不能当作没有该claim而跳过校验
+func (claims JWTClaims) time(name string) (t time.Time, ok bool, err error) {
+	raw, present := claims[name]
+	if !present {
+		return time.Time{}, false, nil
+	}
+	v, isNumber := raw.(float64)
+	if !isNumber {
+		return time.Time{}, false, ErrJWTMalformed
+	}
+	return time.Unix(int64(v), 0), true, nil
+}
+
+func (claims JWTClaims) hasAudience(aud string) bool {
+	switch v := claims["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 常用claims的读取
+
+func (claims JWTClaims) Subject() string {
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+func (claims JWTClaims) GetString(name string) string {
+	v, _ := claims[name].(string)
+	return v
+}
+
+func (claims JWTClaims) Require(names ...string) error {
+	for _, name := range names {
+		if _, ok := claims[name]; !ok {
+			return fmt.Errorf("jwt: claim %q required", name)
+		}
+	}
+	return nil
+}
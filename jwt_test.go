@@ -0,0 +1,276 @@
+package GoMatrix
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var (
+	testRSAKey, _   = rsa.GenerateKey(rand.Reader, 2048)
+	testECDSAKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testHMACSecret  = []byte("0123456789abcdef0123456789abcdef")
+)
+
+func encodeJWTSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// 按alg签名，key为HS256的密钥、*rsa.PrivateKey或*ecdsa.PrivateKey
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims JWTClaims) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	input := encodeJWTSegment(t, header) + "." + encodeJWTSegment(t, claims)
+	sum := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case nil:
+	default:
+		t.Fatalf("unsupported key %T", key)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestParseJWTSignatures(t *testing.T) {
+	otherRSA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherECDSA, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tests := []struct {
+		name  string
+		key   JWTKey
+		token func(t *testing.T) string
+		err   error
+	}{
+		{"hs256", JWTKey{HS256, testHMACSecret}, func(t *testing.T) string {
+			return signJWT(t, HS256, "", testHMACSecret, JWTClaims{"sub": "u1"})
+		}, nil},
+		{"rs256", JWTKey{RS256, &testRSAKey.PublicKey}, func(t *testing.T) string {
+			return signJWT(t, RS256, "", testRSAKey, JWTClaims{"sub": "u1"})
+		}, nil},
+		{"es256", JWTKey{ES256, &testECDSAKey.PublicKey}, func(t *testing.T) string {
+			return signJWT(t, ES256, "", testECDSAKey, JWTClaims{"sub": "u1"})
+		}, nil},
+		{"hs256 wrong secret", JWTKey{HS256, testHMACSecret}, func(t *testing.T) string {
+			return signJWT(t, HS256, "", []byte("another secret"), JWTClaims{"sub": "u1"})
+		}, ErrJWTSignature},
+		{"rs256 wrong key", JWTKey{RS256, &testRSAKey.PublicKey}, func(t *testing.T) string {
+			return signJWT(t, RS256, "", otherRSA, JWTClaims{"sub": "u1"})
+		}, ErrJWTSignature},
+		{"es256 wrong key", JWTKey{ES256, &testECDSAKey.PublicKey}, func(t *testing.T) string {
+			return signJWT(t, ES256, "", otherECDSA, JWTClaims{"sub": "u1"})
+		}, ErrJWTSignature},
+		{"es256 truncated signature", JWTKey{ES256, &testECDSAKey.PublicKey}, func(t *testing.T) string {
+			token := signJWT(t, ES256, "", testECDSAKey, JWTClaims{"sub": "u1"})
+			return token[:len(token)-4]
+		}, ErrJWTSignature},
+		{"tampered claims", JWTKey{HS256, testHMACSecret}, func(t *testing.T) string {
+			token := signJWT(t, HS256, "", testHMACSecret, JWTClaims{"sub": "u1"})
+			other := signJWT(t, HS256, "", testHMACSecret, JWTClaims{"sub": "admin"})
+			return token[:len(token)-43] + other[len(other)-43:]
+		}, ErrJWTSignature},
+		{"malformed", JWTKey{HS256, testHMACSecret}, func(t *testing.T) string {
+			return "a.b"
+		}, ErrJWTMalformed},
+		{"key type mismatch", JWTKey{RS256, testHMACSecret}, func(t *testing.T) string {
+			return signJWT(t, RS256, "", testRSAKey, JWTClaims{"sub": "u1"})
+		}, errJWTKeyMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ParseJWT(tt.token(t), JWTConfig{Keys: map[string]JWTKey{"": tt.key}})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseJWT() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && claims.Subject() != "u1" {
+				t.Fatalf("Subject() = %q", claims.Subject())
+			}
+		})
+	}
+}
+
+// 攻击者把token的alg改为HS256并用公开的RSA公钥作为HMAC密钥签名
+func TestParseJWTAlgorithmConfusion(t *testing.T) {
+	pub, err := x509.MarshalPKIXPublicKey(&testRSAKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := JWTConfig{Keys: map[string]JWTKey{"": {RS256, &testRSAKey.PublicKey}}}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"hs256 with public key", signJWT(t, HS256, "", pub, JWTClaims{"sub": "admin"})},
+		{"none", signJWT(t, "none", "", nil, JWTClaims{"sub": "admin"})},
+		{"es256", signJWT(t, ES256, "", testECDSAKey, JWTClaims{"sub": "admin"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseJWT(tt.token, conf); !errors.Is(err, ErrJWTAlgorithm) {
+				t.Fatalf("ParseJWT() error = %v, want ErrJWTAlgorithm", err)
+			}
+		})
+	}
+}
+
+func TestParseJWTKeyRotation(t *testing.T) {
+	oldSecret := []byte("old secret old secret old secret")
+	conf := JWTConfig{Keys: map[string]JWTKey{
+		"2023": {HS256, oldSecret},
+		"2024": {ES256, &testECDSAKey.PublicKey},
+	}}
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"old key", signJWT(t, HS256, "2023", oldSecret, JWTClaims{}), nil},
+		{"new key", signJWT(t, ES256, "2024", testECDSAKey, JWTClaims{}), nil},
+		{"old key with new kid", signJWT(t, HS256, "2024", oldSecret, JWTClaims{}), ErrJWTAlgorithm},
+		{"unknown kid", signJWT(t, HS256, "2022", oldSecret, JWTClaims{}), ErrJWTUnknownKey},
+		{"missing kid", signJWT(t, HS256, "", oldSecret, JWTClaims{}), ErrJWTUnknownKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseJWT(tt.token, conf); !errors.Is(err, tt.err) {
+				t.Fatalf("ParseJWT() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	t.Run("key func", func(t *testing.T) {
+		var asked string
+		conf := JWTConfig{KeyFunc: func(kid string) (JWTKey, error) {
+			asked = kid
+			return JWTKey{HS256, oldSecret}, nil
+		}}
+		if _, err := ParseJWT(signJWT(t, HS256, "k1", oldSecret, JWTClaims{}), conf); err != nil || asked != "k1" {
+			t.Fatalf("ParseJWT() error = %v, kid = %q", err, asked)
+		}
+	})
+}
+
+func TestJWTClaimsValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	unix := func(d time.Duration) float64 {
+		return float64(now.Add(d).Unix())
+	}
+	tests := []struct {
+		name   string
+		conf   JWTConfig
+		claims JWTClaims
+		err    error
+	}{
+		{"no time claims", JWTConfig{}, JWTClaims{}, nil},
+		{"valid", JWTConfig{}, JWTClaims{"exp": unix(time.Minute), "nbf": unix(-time.Minute), "iat": unix(-time.Minute)}, nil},
+		{"expired", JWTConfig{}, JWTClaims{"exp": unix(-time.Second)}, ErrJWTExpired},
+		{"expired within leeway", JWTConfig{ClockSkew: time.Minute}, JWTClaims{"exp": unix(-30 * time.Second)}, nil},
+		{"expired beyond leeway", JWTConfig{ClockSkew: time.Minute}, JWTClaims{"exp": unix(-2 * time.Minute)}, ErrJWTExpired},
+		{"not yet valid", JWTConfig{}, JWTClaims{"nbf": unix(time.Second)}, ErrJWTNotYetValid},
+		{"nbf within leeway", JWTConfig{ClockSkew: time.Minute}, JWTClaims{"nbf": unix(30 * time.Second)}, nil},
+		{"issued in the future", JWTConfig{}, JWTClaims{"iat": unix(time.Minute)}, ErrJWTIssuedLater},
+		{"iat within leeway", JWTConfig{ClockSkew: time.Minute}, JWTClaims{"iat": unix(30 * time.Second)}, nil},
+		{"exp as string", JWTConfig{}, JWTClaims{"exp": "1"}, ErrJWTMalformed},
+		{"nbf as bool", JWTConfig{}, JWTClaims{"nbf": true}, ErrJWTMalformed},
+		{"iat as null", JWTConfig{}, JWTClaims{"iat": nil}, ErrJWTMalformed},
+		{"issuer", JWTConfig{Issuer: "auth"}, JWTClaims{"iss": "auth"}, nil},
+		{"issuer mismatch", JWTConfig{Issuer: "auth"}, JWTClaims{"iss": "other"}, ErrJWTIssuer},
+		{"audience string", JWTConfig{Audience: "api"}, JWTClaims{"aud": "api"}, nil},
+		{"audience array", JWTConfig{Audience: "api"}, JWTClaims{"aud": []interface{}{"web", "api"}}, nil},
+		{"audience string mismatch", JWTConfig{Audience: "api"}, JWTClaims{"aud": "web"}, ErrJWTAudience},
+		{"audience array mismatch", JWTConfig{Audience: "api"}, JWTClaims{"aud": []interface{}{"web"}}, ErrJWTAudience},
+		{"audience missing", JWTConfig{Audience: "api"}, JWTClaims{}, ErrJWTAudience},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.claims.validate(tt.conf, now); !errors.Is(err, tt.err) {
+				t.Fatalf("validate() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// claims经过JSON解码后再校验，与真实token的类型一致
+func TestParseJWTNonNumericTimeClaims(t *testing.T) {
+	conf := JWTConfig{Keys: map[string]JWTKey{"": {HS256, testHMACSecret}}}
+	for _, claims := range []JWTClaims{{"exp": "1"}, {"nbf": true}, {"iat": map[string]interface{}{}}} {
+		if _, err := ParseJWT(signJWT(t, HS256, "", testHMACSecret, claims), conf); !errors.Is(err, ErrJWTMalformed) {
+			t.Errorf("ParseJWT(%v) error = %v, want ErrJWTMalformed", claims, err)
+		}
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	engine := New()
+	engine.SetMode(ReleaseMode)
+	engine.Use(JWT(JWTConfig{Keys: map[string]JWTKey{"": {HS256, testHMACSecret}}, Audience: "api"}))
+	engine.GET("/me", func(c *Context) {
+		claims := c.MustGet(AuthUserKey).(JWTClaims)
+		c.String(http.StatusOK, claims.Subject())
+	})
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		body          string
+	}{
+		{"valid", "Bearer " + signJWT(t, HS256, "", testHMACSecret, JWTClaims{"sub": "u1", "aud": "api"}), http.StatusOK, "u1"},
+		{"missing", "", http.StatusUnauthorized, ""},
+		{"wrong scheme", "Basic dTpw", http.StatusUnauthorized, ""},
+		{"wrong audience", "Bearer " + signJWT(t, HS256, "", testHMACSecret, JWTClaims{"sub": "u1", "aud": "web"}), http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != tt.body {
+				t.Fatalf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("WWW-Authenticate header missing")
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package GoMatrix
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// 运行模式，release模式下不输出Debug级别的日志（例如路由注册信息）
+
+const (
+	DebugMode   = "debug"
+	ReleaseMode = "release"
+)
+
+// 框架内部日志的环境变量开关，值为debug或release
+const EnvMode = "GOMATRIX_MODE"
+
+// 框架内部使用的分级日志，可以接入zap、slog等日志库
+
+type LevelLogger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// 默认使用标准库log输出
+
+type defaultLogger struct{}
+
+func (defaultLogger) Debugf(format string, args ...interface{}) {
+	log.Printf("[DEBUG] "+format, args...)
+}
+
+func (defaultLogger) Infof(format string, args ...interface{}) {
+	log.Printf("[INFO] "+format, args...)
+}
+
+func (defaultLogger) Warnf(format string, args ...interface{}) {
+	log.Printf("[WARN] "+format, args...)
+}
+
+func (defaultLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("[ERROR] "+format, args...)
+}
+
+// 按模式过滤Debug日志，其它级别直接交给使用者的日志库
+
+type modeLogger struct {
+	LevelLogger
+	engine *Engine
+}
+
+func (l modeLogger) Debugf(format string, args ...interface{}) {
+	if l.engine.mode == ReleaseMode {
+		return
+	}
+	l.LevelLogger.Debugf(format, args...)
+}
+
+// 将http.Server自身的错误日志（例如TLS握手失败）转为Error级别
+
+type serverErrorWriter struct {
+	engine *Engine
+}
+
+func (w serverErrorWriter) Write(p []byte) (int, error) {
+	w.engine.logger.Errorf("%s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func modeFromEnv() string {
+	if os.Getenv(EnvMode) == ReleaseMode {
+		return ReleaseMode
+	}
+	return DebugMode
+}
+
+// 替换框架内部日志
+
+func (engine *Engine) SetLogger(logger LevelLogger) {
+	engine.logger = modeLogger{LevelLogger: logger, engine: engine}
+}
+
+// 切换debug/release模式
+
+func (engine *Engine) SetMode(mode string) {
+	assert1(mode == DebugMode || mode == ReleaseMode, "GoMatrix mode must be debug or release")
+	engine.mode = mode
+}
+
+func (engine *Engine) Mode() string {
+	return engine.mode
+}
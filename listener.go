@@ -0,0 +1,141 @@
+package GoMatrix
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// systemd socket activation传入的第一个fd
+
+const listenFdsStart = 3
+
+// 在已有的监听器上启动服务，可以在多个goroutine中分别调用以同时监听多个地址
+
+func (engine *Engine) RunListener(listener net.Listener, maxConn int) (err error) {
+	engine.serverMu.Lock()
+	engine.maxConn = maxConn
+	engine.serverMu.Unlock()
+	srv := engine.currentServer()
+	engine.logger.Infof("start server on %s %s ,workers:%d", listener.Addr().Network(), listener.Addr(), maxConn)
+	return engine.serve(srv, listener, maxConn)
+}
+
+// 监听Unix domain socket，已存在的socket文件会被删除，perm为socket文件的权限
+
+func (engine *Engine) RunUnix(file string, perm os.FileMode, maxConn int) (err error) {
+	if fi, err := os.Lstat(file); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("unix", file)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(file, perm); err != nil {
+		listener.Close()
+		return err
+	}
+	return engine.RunListener(listener, maxConn)
+}
+
+// 在继承来的文件描述符上启动服务
+
+func (engine *Engine) RunFd(fd int, maxConn int) (err error) {
+	f := os.NewFile(uintptr(fd), "fd@"+strconv.Itoa(fd))
+	if f == nil {
+		return fmt.Errorf("GoMatrix: invalid file descriptor %d", fd)
+	}
+	listener, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return engine.RunListener(listener, maxConn)
+}
+
+// 在systemd socket activation传入的所有fd上启动服务，任意一个监听器退出即返回
+
+func (engine *Engine) RunSystemd(maxConn int) (err error) {
+	fds, err := systemdFds()
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, len(fds))
+	for _, fd := range fds {
+		go func(fd int) {
+			errs <- engine.RunFd(fd, maxConn)
+		}(fd)
+	}
+	return <-errs
+}
+
+// 解析LISTEN_PID与LISTEN_FDS，读取后清除环境变量，避免子进程重复继承
+
+func systemdFds() ([]int, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("GoMatrix: LISTEN_PID does not match current process")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, errors.New("GoMatrix: no file descriptors passed by systemd")
+	}
+	fds := make([]int, n)
+	for i := range fds {
+		fds[i] = listenFdsStart + i
+	}
+	return fds, nil
+}
+
+// 记录接受的连接，直到连接被关闭。被劫持的连接不再有http.ConnState通知，
+// 只能在底层连接上记录，Shutdown据此等待并强制关闭它们
+
+type trackingListener struct {
+	net.Listener
+	engine *Engine
+	conns  map[net.Conn]struct{}
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc := &trackedConn{Conn: conn, listener: l}
+	l.engine.serverMu.Lock()
+	l.conns[tc] = struct{}{}
+	l.engine.serverMu.Unlock()
+	return tc, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	listener *trackingListener
+	once     sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.listener.engine.serverMu.Lock()
+		delete(c.listener.conns, c)
+		c.listener.engine.serverMu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// 透传ReadFrom，net/http依赖它在TCP连接上使用sendfile
+func (c *trackedConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{c.Conn}, r)
+}
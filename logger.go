@@ -1,7 +1,14 @@
 package GoMatrix
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -9,6 +16,178 @@ func Logger() HandlerFunc {
 	return func(c *Context) {
 		t := time.Now()
 		c.Next()
+		if id := c.GetString(RequestIDKey); id != "" {
+			log.Printf("[%d] %s in %v request_id=%s", c.StatusCode, c.Req.RequestURI, time.Since(t), id)
+			return
+		}
 		log.Printf("[%d] %s in %v", c.StatusCode, c.Req.RequestURI, time.Since(t))
 	}
 }
+
+// 格式化一条访问日志需要的信息
+
+type LogFormatterParams struct {
+	Request    *http.Request
+	TimeStamp  time.Time
+	StatusCode int
+	Latency    time.Duration
+	ClientIP   string
+	Method     string
+	Path       string
+	Proto      string
+	BodySize   int
+	UserAgent  string
+	Referer    string
+	RequestID  string
+	// 超过LoggerConfig.SlowThreshold的慢请求
+	Slow bool
+}
+
+type LogFormatter func(params LogFormatterParams) string
+
+type LoggerConfig struct {
+	// 日志格式，默认与Logger一致
+	Formatter LogFormatter
+	// 日志输出，默认os.Stdout
+	Output io.Writer
+	// 不记录日志的路径
+	SkipPaths []string
+	// 返回true时不记录日志
+	Skip func(c *Context) bool
+	// 大于0时，超过该耗时的请求额外输出一条慢请求警告
+	SlowThreshold time.Duration
+}
+
+// 可配置的访问日志中间件
+
+func LoggerWithConfig(conf LoggerConfig) HandlerFunc {
+	formatter := conf.Formatter
+	if formatter == nil {
+		formatter = defaultLogFormatter
+	}
+	out := conf.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	skip := make(map[string]struct{}, len(conf.SkipPaths))
+	for _, p := range conf.SkipPaths {
+		skip[p] = struct{}{}
+	}
+	// Output不一定是并发安全的
+	var mu sync.Mutex
+
+	return func(c *Context) {
+		start := time.Now()
+		path := c.Req.URL.Path
+		c.Next()
+
+		if _, ok := skip[path]; ok {
+			return
+		}
+		if conf.Skip != nil && conf.Skip(c) {
+			return
+		}
+		params := LogFormatterParams{
+			Request:    c.Req,
+			TimeStamp:  time.Now(),
+			StatusCode: c.writermem.status,
+			ClientIP:   c.ClientIP(),
+			Method:     c.Req.Method,
+			Path:       c.Req.RequestURI,
+			Proto:      c.Req.Proto,
+			BodySize:   c.writermem.size,
+			UserAgent:  c.Req.UserAgent(),
+			Referer:    c.Req.Referer(),
+			RequestID:  c.GetString(RequestIDKey),
+		}
+		params.Latency = params.TimeStamp.Sub(start)
+		if params.BodySize < 0 {
+			params.BodySize = 0
+		}
+		if conf.SlowThreshold > 0 && params.Latency > conf.SlowThreshold {
+			params.Slow = true
+			c.engine.logger.Warnf("slow request %s %s took %v (threshold %v) request_id=%s", params.Method, params.Path, params.Latency, conf.SlowThreshold, params.RequestID)
+		}
+		line := formatter(params)
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		mu.Lock()
+		io.WriteString(out, line)
+		mu.Unlock()
+	}
+}
+
+func defaultLogFormatter(p LogFormatterParams) string {
+	line := fmt.Sprintf("%s [%d] %s in %v", p.TimeStamp.Format("2006/01/02 15:04:05"), p.StatusCode, p.Path, p.Latency)
+	if p.RequestID != "" {
+		line += " request_id=" + p.RequestID
+	}
+	return line
+}
+
+func clfValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Common Log Format: host ident authuser [date] "request" status bytes
+
+func CommonLogFormatter(p LogFormatterParams) string {
+	user := "-"
+	if p.Request != nil && p.Request.URL.User != nil {
+		user = clfValue(p.Request.URL.User.Username())
+	}
+	size := "-"
+	if p.BodySize > 0 {
+		size = fmt.Sprint(p.BodySize)
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		clfValue(p.ClientIP), user, p.TimeStamp.Format("02/Jan/2006:15:04:05 -0700"),
+		p.Method, p.Path, p.Proto, p.StatusCode, size)
+}
+
+// Combined Log Format: 在Common Log Format的基础上追加referer与user-agent
+
+func CombinedLogFormatter(p LogFormatterParams) string {
+	return fmt.Sprintf("%s \"%s\" \"%s\"", CommonLogFormatter(p), clfValue(p.Referer), clfValue(p.UserAgent))
+}
+
+// 每个请求输出一行JSON
+
+func JSONLogFormatter(p LogFormatterParams) string {
+	entry := struct {
+		Time      string  `json:"time"`
+		Status    int     `json:"status"`
+		Latency   float64 `json:"latency_ms"`
+		ClientIP  string  `json:"client_ip"`
+		Method    string  `json:"method"`
+		Path      string  `json:"path"`
+		Proto     string  `json:"proto"`
+		BodySize  int     `json:"bytes"`
+		UserAgent string  `json:"user_agent,omitempty"`
+		Referer   string  `json:"referer,omitempty"`
+		RequestID string  `json:"request_id,omitempty"`
+		Slow      bool    `json:"slow,omitempty"`
+	}{
+		Time:      p.TimeStamp.Format(time.RFC3339Nano),
+		Status:    p.StatusCode,
+		Latency:   float64(p.Latency) / float64(time.Millisecond),
+		ClientIP:  p.ClientIP,
+		Method:    p.Method,
+		Path:      p.Path,
+		Proto:     p.Proto,
+		BodySize:  p.BodySize,
+		UserAgent: p.UserAgent,
+		Referer:   p.Referer,
+		RequestID: p.RequestID,
+		Slow:      p.Slow,
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(b)
+}
@@ -0,0 +1,397 @@
+package GoMatrix
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的耗时分桶（秒）与响应大小分桶（字节）
+
+var (
+	DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	DefaultSizeBuckets     = []float64{100, 1000, 10000, 100000, 1e6, 1e7}
+)
+
+// 指标注册表，输出Prometheus文本格式
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+// 默认注册表，Metrics中间件与MetricsHandler默认使用它
+
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu       sync.Mutex
+	children map[string]metricChild
+}
+
+type metricChild interface {
+	write(w io.Writer, name, labels string)
+}
+
+// 同名指标只注册一次，重复注册时返回已有的指标，类型或标签不一致时panic
+func (r *Registry) register(name, help, typ string, labels []string, buckets []float64) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		assert1(m.typ == typ && strings.Join(m.labels, ",") == strings.Join(labels, ","),
+			"metric "+name+" already registered with a different type or labels")
+		return m
+	}
+	m := &metric{
+		name:     name,
+		help:     help,
+		typ:      typ,
+		labels:   labels,
+		buckets:  buckets,
+		children: make(map[string]metricChild),
+	}
+	r.metrics[name] = m
+	return m
+}
+
+func (m *metric) child(values []string, create func() metricChild) metricChild {
+	assert1(len(values) == len(m.labels), "metric "+m.name+" label values do not match labels")
+	key := strings.Join(values, "\xff")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.children[key]
+	if !ok {
+		c = create()
+		m.children[key] = c
+	}
+	return c
+}
+
+type CounterVec struct {
+	m *metric
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{m: r.register(name, help, "counter", labels, nil)}
+}
+
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.m.child(values, func() metricChild { return &Counter{} }).(*Counter)
+}
+
+// 只增不减的计数器
+
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	assert1(v >= 0, "counter can not decrease")
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer, name, labels string) {
+	c.mu.Lock()
+	value := c.value
+	c.mu.Unlock()
+	fmt.Fprintf(w, "%s%s %s\n", name, wrapLabels(labels), formatFloat(value))
+}
+
+type GaugeVec struct {
+	m *metric
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{m: r.register(name, help, "gauge", labels, nil)}
+}
+
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return v.m.child(values, func() metricChild { return &Gauge{} }).(*Gauge)
+}
+
+// 可增可减的当前值
+
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) write(w io.Writer, name, labels string) {
+	g.mu.Lock()
+	value := g.value
+	g.mu.Unlock()
+	fmt.Fprintf(w, "%s%s %s\n", name, wrapLabels(labels), formatFloat(value))
+}
+
+type HistogramVec struct {
+	m *metric
+}
+
+// buckets为各个桶的上界，为空时使用DefaultDurationBuckets
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &HistogramVec{m: r.register(name, help, "histogram", labels, sorted)}
+}
+
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.m.child(values, func() metricChild {
+		return &Histogram{buckets: v.m.buckets, counts: make([]uint64, len(v.m.buckets))}
+	}).(*Histogram)
+}
+
+// 直方图，按桶统计观测值的分布
+
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func (h *Histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, prefix, formatFloat(upper), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, wrapLabels(labels), formatFloat(sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, wrapLabels(labels), count)
+}
+
+// 按名称与标签排序输出所有指标
+
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", m.name, m.typ)
+		m.mu.Lock()
+		keys := make([]string, 0, len(m.children))
+		for k := range m.children {
+			keys = append(keys, k)
+		}
+		children := make([]metricChild, len(keys))
+		sort.Strings(keys)
+		for i, k := range keys {
+			children[i] = m.children[k]
+		}
+		m.mu.Unlock()
+		for i, child := range children {
+			child.write(bw, m.name, m.labelPairs(keys[i]))
+		}
+	}
+	return bw.Flush()
+}
+
+func (m *metric) labelPairs(key string) string {
+	if len(m.labels) == 0 {
+		return ""
+	}
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, len(m.labels))
+	for i, label := range m.labels {
+		pairs[i] = label + `="` + escapeLabelValue(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+var (
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 指标的文本输出接口，例如r.GET("/metrics", GoMatrix.MetricsHandler(nil))，registry为空时使用DefaultRegistry
+
+func MetricsHandler(registry *Registry) HandlerFunc {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return func(c *Context) {
+		c.SetHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := registry.Write(c.Writer); err != nil {
+			c.engine.logger.Errorf("write metrics failed: %v", err)
+		}
+	}
+}
+
+type MetricsConfig struct {
+	// 指标注册表，默认DefaultRegistry
+	Registry *Registry
+	// 指标名称前缀，默认http
+	Namespace string
+	// 请求耗时与响应大小的分桶
+	DurationBuckets []float64
+	SizeBuckets     []float64
+	// 不统计的路由，例如/metrics本身
+	SkipPaths []string
+}
+
+// 没有匹配到路由的请求使用的标签，避免按原始路径产生大量标签
+const unmatchedRoute = "unmatched"
+
+// 请求指标中间件：请求数、耗时、处理中的请求数与响应大小，按方法与路由模式（而不是原始路径）打标签
+
+func Metrics(config ...MetricsConfig) HandlerFunc {
+	var conf MetricsConfig
+	if len(config) > 0 {
+		conf = config[0]
+	}
+	if conf.Registry == nil {
+		conf.Registry = DefaultRegistry
+	}
+	if conf.Namespace == "" {
+		conf.Namespace = "http"
+	}
+	if len(conf.SizeBuckets) == 0 {
+		conf.SizeBuckets = DefaultSizeBuckets
+	}
+	skip := make(map[string]bool, len(conf.SkipPaths))
+	for _, p := range conf.SkipPaths {
+		skip[p] = true
+	}
+	r, ns := conf.Registry, conf.Namespace
+	requests := r.NewCounterVec(ns+"_requests_total", "Total number of HTTP requests.", "method", "route", "code")
+	duration := r.NewHistogramVec(ns+"_request_duration_seconds", "HTTP request latency in seconds.", conf.DurationBuckets, "method", "route")
+	inFlight := r.NewGaugeVec(ns+"_requests_in_flight", "Number of HTTP requests being served.", "method", "route")
+	size := r.NewHistogramVec(ns+"_response_size_bytes", "HTTP response size in bytes.", conf.SizeBuckets, "method", "route")
+
+	return func(c *Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		if skip[route] {
+			c.Next()
+			return
+		}
+		method := metricsMethod(c.Method)
+		start := time.Now()
+		gauge := inFlight.WithLabelValues(method, route)
+		gauge.Inc()
+		defer gauge.Dec()
+		c.Next()
+		written := c.writermem.size
+		if written < 0 {
+			written = 0
+		}
+		requests.WithLabelValues(method, route, strconv.Itoa(c.writermem.status)).Inc()
+		duration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		size.WithLabelValues(method, route).Observe(float64(written))
+	}
+}
+
+// 客户端可以发送任意方法，非标准的方法统一记为OTHER，避免产生大量的时间序列
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
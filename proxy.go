@@ -0,0 +1,223 @@
+package GoMatrix
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 设置受信任的代理，支持CIDR与单个IP。只有直接连接的对端在其中时，
+// 才会读取SetRemoteIPHeaders设置的请求头；默认不信任任何代理
+
+func (engine *Engine) SetTrustedProxies(proxies []string) error {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return &net.ParseError{Type: "IP address", Text: proxy}
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			proxy += "/" + strconv.Itoa(bits)
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return err
+		}
+		nets = append(nets, ipNet)
+	}
+	engine.trustedProxies = nets
+	return nil
+}
+
+func (engine *Engine) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range engine.trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// 默认只信任代理追加的X-Forwarded-For
+var defaultRemoteIPHeaders = []string{"X-Forwarded-For"}
+
+// 设置从哪些请求头中解析客户端IP，按顺序尝试，可选Forwarded、X-Forwarded-For、X-Real-IP等。
+// 只应该包含受信任的代理会写入的请求头，代理原样透传的请求头由客户端控制；不传参数时不解析任何请求头。
+// 包含Forwarded时，Scheme与Host使用Forwarded中的proto与host，否则使用X-Forwarded-Proto与X-Forwarded-Host
+
+func (engine *Engine) SetRemoteIPHeaders(headers ...string) {
+	engine.remoteIPHeaders = append([]string{}, headers...)
+}
+
+func (engine *Engine) clientIPHeaders() []string {
+	if engine.remoteIPHeaders == nil {
+		return defaultRemoteIPHeaders
+	}
+	return engine.remoteIPHeaders
+}
+
+func (engine *Engine) useForwarded() bool {
+	for _, h := range engine.clientIPHeaders() {
+		if strings.EqualFold(h, "Forwarded") {
+			return true
+		}
+	}
+	return false
+}
+
+// 客户端的真实IP：对端是受信任的代理时，依次从SetRemoteIPHeaders设置的请求头中
+// 从右向左跳过受信任的代理，取第一个不受信任的地址
+
+func (c *Context) ClientIP() string {
+	peer := remoteIP(c.Req)
+	if !c.engine.isTrustedProxy(peer) {
+		return peer
+	}
+	for _, name := range c.engine.clientIPHeaders() {
+		var chain []string
+		if strings.EqualFold(name, "Forwarded") {
+			chain = forwardedValues(c.Req.Header, "for")
+		} else {
+			chain = headerList(c.Req.Header, name)
+		}
+		if ip := c.engine.firstUntrusted(chain); ip != "" {
+			return ip
+		}
+	}
+	return peer
+}
+
+// 从右向左遍历代理链，全部受信任时返回最左边的地址
+
+func (engine *Engine) firstUntrusted(chain []string) string {
+	var ip string
+	for i := len(chain) - 1; i >= 0; i-- {
+		parsed := parseForwardedIP(chain[i])
+		if parsed == nil {
+			// 无法解析（例如unknown或混淆标识）时不再继续向左信任
+			return ip
+		}
+		ip = parsed.String()
+		if !engine.isTrustedProxy(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// 支持1.2.3.4、1.2.3.4:80、[::1]、[::1]:80
+func parseForwardedIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+// 请求的协议，http或https。代理通常追加而不是覆盖请求头，只取最近的代理写入的最右边的值
+
+func (c *Context) Scheme() string {
+	if proto := c.forwardedParam("proto", "X-Forwarded-Proto"); proto != "" {
+		return strings.ToLower(proto)
+	}
+	if c.Req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// 客户端请求的host，取值方式与Scheme相同
+
+func (c *Context) Host() string {
+	if host := c.forwardedParam("host", "X-Forwarded-Host"); host != "" {
+		return host
+	}
+	return c.Req.Host
+}
+
+func (c *Context) forwardedParam(key, header string) string {
+	if !c.engine.isTrustedProxy(remoteIP(c.Req)) {
+		return ""
+	}
+	var values []string
+	if c.engine.useForwarded() {
+		values = forwardedValues(c.Req.Header, key)
+	} else {
+		values = headerList(c.Req.Header, header)
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// 合并同名请求头并按逗号切分
+func headerList(header http.Header, name string) []string {
+	var values []string
+	for _, line := range header.Values(name) {
+		for _, part := range strings.Split(line, ",") {
+			values = append(values, strings.TrimSpace(part))
+		}
+	}
+	return values
+}
+
+// 按顺序取出RFC 7239 Forwarded头中每个元素的某个参数，元素中没有该参数时为空字符串，
+// 例如Forwarded: for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"
+
+func forwardedValues(header http.Header, key string) []string {
+	var values []string
+	for _, line := range header.Values("Forwarded") {
+		for _, element := range splitQuoted(line, ',') {
+			value := ""
+			for _, pair := range splitQuoted(element, ';') {
+				i := strings.IndexByte(pair, '=')
+				if i < 0 || !strings.EqualFold(strings.TrimSpace(pair[:i]), key) {
+					continue
+				}
+				v := strings.TrimSpace(pair[i+1:])
+				if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+					v = strings.ReplaceAll(v[1:len(v)-1], `\"`, `"`)
+				}
+				value = v
+			}
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// 按分隔符切分，忽略引号内的分隔符
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,174 @@
+package GoMatrix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPSchemeHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		peer    string
+		header  http.Header
+		ip      string
+		scheme  string
+		host    string
+	}{
+		{
+			name:   "untrusted peer ignores headers",
+			peer:   "203.0.113.7:1234",
+			header: http.Header{"X-Forwarded-For": {"1.1.1.1"}, "X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"evil.com"}},
+			ip:     "203.0.113.7",
+			scheme: "http",
+			host:   "example.com",
+		},
+		{
+			name:   "x-forwarded-for",
+			peer:   "10.0.0.5:1234",
+			header: http.Header{"X-Forwarded-For": {"203.0.113.9"}},
+			ip:     "203.0.113.9",
+			scheme: "http",
+			host:   "example.com",
+		},
+		{
+			name:   "client forwarded header is not trusted by default",
+			peer:   "10.0.0.5:1234",
+			header: http.Header{"X-Forwarded-For": {"203.0.113.9"}, "Forwarded": {"for=192.168.1.1"}},
+			ip:     "203.0.113.9",
+			scheme: "http",
+			host:   "example.com",
+		},
+		{
+			name:   "x-real-ip is not trusted by default",
+			peer:   "10.0.0.5:1234",
+			header: http.Header{"X-Real-Ip": {"192.168.1.1"}},
+			ip:     "10.0.0.5",
+			scheme: "http",
+			host:   "example.com",
+		},
+		{
+			name:   "client supplied prefix in x-forwarded-for",
+			peer:   "10.0.0.5:1234",
+			header: http.Header{"X-Forwarded-For": {"192.168.1.1, 203.0.113.9", "10.0.0.6"}},
+			ip:     "203.0.113.9",
+			scheme: "http",
+			host:   "example.com",
+		},
+		{
+			name:   "all trusted returns leftmost",
+			peer:   "10.0.0.5:1234",
+			header: http.Header{"X-Forwarded-For": {"10.0.0.7, 10.0.0.6"}},
+			ip:     "10.0.0.7",
+			scheme: "http",
+			host:   "example.com",
+		},
+		{
+			name:   "unparsable hop stops the walk",
+			peer:   "10.0.0.5:1234",
+			header: http.Header{"X-Forwarded-For": {"203.0.113.9, unknown"}},
+			ip:     "10.0.0.5",
+			scheme: "http",
+			host:   "example.com",
+		},
+		{
+			name:   "proto and host use the value appended by the nearest proxy",
+			peer:   "10.0.0.5:1234",
+			header: http.Header{"X-Forwarded-Proto": {"https, http"}, "X-Forwarded-Host": {"evil.com", "example.org"}},
+			ip:     "10.0.0.5",
+			scheme: "http",
+			host:   "example.org",
+		},
+		{
+			name:    "forwarded when configured",
+			headers: []string{"Forwarded"},
+			peer:    "10.0.0.5:1234",
+			header: http.Header{
+				"Forwarded":       {`for=192.168.1.1;proto=http, for="[2001:db8::1]:4711";proto=https;host=example.org`},
+				"X-Forwarded-For": {"203.0.113.9"},
+			},
+			ip:     "2001:db8::1",
+			scheme: "https",
+			host:   "example.org",
+		},
+		{
+			name:    "forwarded element without proto is not filled from earlier elements",
+			headers: []string{"Forwarded"},
+			peer:    "10.0.0.5:1234",
+			header:  http.Header{"Forwarded": {"for=1.1.1.1;proto=https;host=evil.com, for=203.0.113.9"}},
+			ip:      "203.0.113.9",
+			scheme:  "http",
+			host:    "example.com",
+		},
+		{
+			name:    "headers tried in order",
+			headers: []string{"X-Real-IP", "X-Forwarded-For"},
+			peer:    "10.0.0.5:1234",
+			header:  http.Header{"X-Forwarded-For": {"203.0.113.9"}},
+			ip:      "203.0.113.9",
+			scheme:  "http",
+			host:    "example.com",
+		},
+		{
+			name:    "no headers configured",
+			headers: []string{},
+			peer:    "10.0.0.5:1234",
+			header:  http.Header{"X-Forwarded-For": {"203.0.113.9"}},
+			ip:      "10.0.0.5",
+			scheme:  "http",
+			host:    "example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := New()
+			if err := engine.SetTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.headers != nil {
+				engine.SetRemoteIPHeaders(tt.headers...)
+			}
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			req.RemoteAddr = tt.peer
+			req.Header = tt.header
+			c := engine.allocateContext()
+			c.newContext(httptest.NewRecorder(), req)
+			if ip := c.ClientIP(); ip != tt.ip {
+				t.Errorf("ClientIP() = %q, want %q", ip, tt.ip)
+			}
+			if scheme := c.Scheme(); scheme != tt.scheme {
+				t.Errorf("Scheme() = %q, want %q", scheme, tt.scheme)
+			}
+			if host := c.Host(); host != tt.host {
+				t.Errorf("Host() = %q, want %q", host, tt.host)
+			}
+		})
+	}
+}
+
+func TestForwardedValues(t *testing.T) {
+	tests := []struct {
+		header string
+		key    string
+		want   []string
+	}{
+		{`for=192.0.2.60;proto=http;by=203.0.113.43`, "for", []string{"192.0.2.60"}},
+		{`for=192.0.2.43, for="[2001:db8:cafe::17]:4711"`, "for", []string{"192.0.2.43", "[2001:db8:cafe::17]:4711"}},
+		{`For="a,b";proto=https, for=c`, "proto", []string{"https", ""}},
+		{`host="x\"y"`, "host", []string{`x"y`}},
+	}
+	for _, tt := range tests {
+		got := forwardedValues(http.Header{"Forwarded": {tt.header}}, tt.key)
+		if len(got) != len(tt.want) {
+			t.Errorf("forwardedValues(%q, %q) = %q, want %q", tt.header, tt.key, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("forwardedValues(%q, %q) = %q, want %q", tt.header, tt.key, got, tt.want)
+				break
+			}
+		}
+	}
+}
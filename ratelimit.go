@@ -0,0 +1,259 @@
+package GoMatrix
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 一次限流判断的结果
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// 距离配额完全恢复的时间
+	Reset time.Duration
+	// 被拒绝时建议的重试等待时间
+	RetryAfter time.Duration
+}
+
+// 限流存储，内存实现之外可以基于redis等共享存储实现
+
+type RateLimitStore interface {
+	Take(key string) (RateLimitResult, error)
+}
+
+type RateLimitConfig struct {
+	// 限流算法与存储，NewTokenBucketStore或NewSlidingWindowStore
+	Store RateLimitStore
+	// 限流的维度，默认按客户端IP
+	KeyFunc func(c *Context) string
+	// 超出限制时的处理，默认返回429
+	Handler func(c *Context, result RateLimitResult)
+}
+
+// 按客户端IP限流
+
+func KeyByIP(c *Context) string {
+	return c.ClientIP()
+}
+
+// 按请求头限流，例如API key。没有该请求头时按ClientIP限流，
+// 否则所有匿名请求共用一个桶，一个客户端就能限制住其它所有人；
+// 两种key加上不同的前缀，请求头的值无法冒充其它IP的桶
+
+func KeyByHeader(name string) func(c *Context) string {
+	return func(c *Context) string {
+		if v := c.GetHeader(name); v != "" {
+			return "header:" + v
+		}
+		return "ip:" + c.ClientIP()
+	}
+}
+
+// 限流中间件，可以挂载在分组上，也可以在注册路由时只作用于单个路由
+
+func RateLimit(conf RateLimitConfig) HandlerFunc {
+	assert1(conf.Store != nil, "rate limit store can not be nil")
+	if conf.KeyFunc == nil {
+		conf.KeyFunc = KeyByIP
+	}
+	if conf.Handler == nil {
+		conf.Handler = func(c *Context, result RateLimitResult) {
+			c.Fail(http.StatusTooManyRequests, "Too Many Requests")
+		}
+	}
+	return func(c *Context) {
+		result, err := conf.Store.Take(conf.KeyFunc(c))
+		if err != nil {
+			// 存储不可用时放行，避免限流拖垮整个服务
+			c.engine.logger.Warnf("rate limit store error: %v", err)
+			c.Next()
+			return
+		}
+		header := c.Writer.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			retry := ceilSeconds(result.RetryAfter)
+			if retry < 1 {
+				retry = 1
+			}
+			header.Set("Retry-After", strconv.Itoa(retry))
+			c.Abort()
+			conf.Handler(c, result)
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// 过期数据的清理间隔
+const rateLimitCleanupInterval = time.Minute
+
+// 令牌桶：以rate的速度补充令牌，最多积累burst个，允许短时突发
+
+type tokenBucketStore struct {
+	rate  float64
+	burst int
+
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rate为每秒补充的令牌数
+
+func NewTokenBucketStore(rate float64, burst int) RateLimitStore {
+	assert1(rate > 0 && burst > 0, "token bucket rate and burst must be positive")
+	return &tokenBucketStore{
+		rate:        rate,
+		burst:       burst,
+		buckets:     make(map[string]*tokenBucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (s *tokenBucketStore) Take(key string) (RateLimitResult, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanup(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(s.burst), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(s.burst), b.tokens+now.Sub(b.last).Seconds()*s.rate)
+	b.last = now
+
+	result := RateLimitResult{Limit: s.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = s.duration(1 - b.tokens)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = s.duration(float64(s.burst) - b.tokens)
+	return result, nil
+}
+
+func (s *tokenBucketStore) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / s.rate * float64(time.Second))
+}
+
+// 已经补满的桶与新建的桶等价，可以删除
+
+func (s *tokenBucketStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < rateLimitCleanupInterval {
+		return
+	}
+	s.lastCleanup = now
+	full := s.duration(float64(s.burst))
+	for key, b := range s.buckets {
+		if now.Sub(b.last) >= full {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// 滑动窗口：用上一个窗口的计数按重叠比例加权，近似任意时刻往前window时间内的请求数
+
+type slidingWindowStore struct {
+	limit  int
+	window time.Duration
+
+	mu          sync.Mutex
+	windows     map[string]*slidingWindow
+	lastCleanup time.Time
+}
+
+type slidingWindow struct {
+	start time.Time
+	curr  int
+	prev  int
+}
+
+// window时间内最多limit次请求
+
+func NewSlidingWindowStore(limit int, window time.Duration) RateLimitStore {
+	assert1(limit > 0 && window > 0, "sliding window limit and window must be positive")
+	return &slidingWindowStore{
+		limit:       limit,
+		window:      window,
+		windows:     make(map[string]*slidingWindow),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (s *slidingWindowStore) Take(key string) (RateLimitResult, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanup(now)
+	w, ok := s.windows[key]
+	if !ok {
+		w = &slidingWindow{start: now.Truncate(s.window)}
+		s.windows[key] = w
+	}
+	// 滚动到当前窗口
+	if elapsed := now.Sub(w.start); elapsed >= s.window {
+		if elapsed < 2*s.window {
+			w.prev = w.curr
+		} else {
+			w.prev = 0
+		}
+		w.curr = 0
+		w.start = now.Truncate(s.window)
+	}
+	elapsed := now.Sub(w.start)
+	weight := 1 - float64(elapsed)/float64(s.window)
+	estimated := float64(w.prev)*weight + float64(w.curr)
+
+	result := RateLimitResult{Limit: s.limit, Reset: s.window - elapsed}
+	if estimated+1 <= float64(s.limit) {
+		w.curr++
+		estimated++
+		result.Allowed = true
+	} else if w.curr+1 > s.limit || w.prev == 0 {
+		result.RetryAfter = s.window - elapsed
+	} else {
+		// 等到上一个窗口的权重下降到足够放行一次
+		need := 1 - float64(s.limit-w.curr-1)/float64(w.prev)
+		result.RetryAfter = time.Duration(need*float64(s.window)) - elapsed
+	}
+	result.Remaining = s.limit - int(math.Ceil(estimated))
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	return result, nil
+}
+
+func (s *slidingWindowStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < rateLimitCleanupInterval {
+		return
+	}
+	s.lastCleanup = now
+	for key, w := range s.windows {
+		if now.Sub(w.start) >= 2*s.window {
+			delete(s.windows, key)
+		}
+	}
+}
@@ -1,11 +1,17 @@
 package GoMatrix
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"os"
 	"runtime"
+	"runtime/debug"
 	"strings"
+	"syscall"
 )
 
 func trace(message string) string {
@@ -24,16 +30,118 @@ func trace(message string) string {
 	return str.String()
 }
 
+type RecoveryConfig struct {
+	// 自定义的panic处理，err为panic的值，stack为debug.Stack()的调用栈，不包含请求内容，
+	// 可以直接上报给错误追踪服务；为空时返回500
+	Handler func(c *Context, err interface{}, stack []byte)
+	// panic信息的输出位置，为空时使用框架日志的Error级别
+	Output io.Writer
+	// 日志中输出请求时需要脱敏的请求头，默认为Authorization、Proxy-Authorization、Cookie、X-API-Key和X-CSRF-Token
+	RedactHeaders []string
+}
+
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-API-Key", "X-CSRF-Token"}
+
 func Recovery() HandlerFunc {
+	return RecoveryWithConfig(RecoveryConfig{})
+}
+
+// 可配置的错误恢复中间件，http.ErrAbortHandler会继续向上panic，
+// 客户端已断开（broken pipe、connection reset）时不再写入响应
+
+func RecoveryWithConfig(conf RecoveryConfig) HandlerFunc {
+	redact := conf.RedactHeaders
+	if redact == nil {
+		redact = defaultRedactHeaders
+	}
 	return func(c *Context) {
 		defer func() {
-			if err := recover(); err != nil {
-				message := fmt.Sprintf("%s", err)
-				log.Printf("%s\n\n", trace(message))
-				c.Fail(http.StatusInternalServerError, "Internal Server Error")
+			err := recover()
+			if err == nil {
+				return
+			}
+			// Timeout中的panic已经在处理函数的goroutine中记录了调用栈
+			var stack []byte
+			if tp, ok := err.(*timeoutPanic); ok {
+				err, stack = tp.value, tp.stack
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			request := dumpRequest(c.Req, redact)
+			brokenPipe := isBrokenPipe(err)
+			var message string
+			switch {
+			case brokenPipe:
+				message = fmt.Sprintf("%s\n%s", err, request)
+			case stack != nil:
+				message = fmt.Sprintf("%s\nTraceback:\n%s\n%s", err, stack, request)
+			default:
+				message = fmt.Sprintf("%s\n\n%s", trace(fmt.Sprintf("%s", err)), request)
+			}
+			if id := c.GetString(RequestIDKey); id != "" {
+				message = "request_id=" + id + " " + message
+			}
+			if conf.Output != nil {
+				fmt.Fprintf(conf.Output, "[Recovery] panic recovered:\n%s\n\n", message)
+			} else {
+				c.engine.logger.Errorf("%s\n\n", message)
+			}
+			if brokenPipe {
+				// 连接已经断开，写入响应没有意义
+				c.Abort()
+				return
 			}
+			if conf.Handler != nil {
+				if stack == nil {
+					stack = debug.Stack()
+				}
+				c.Abort()
+				conf.Handler(c, err, stack)
+				return
+			}
+			c.Fail(http.StatusInternalServerError, "Internal Server Error")
 		}()
 
 		c.Next()
 	}
 }
+
+// 判断panic是否由客户端断开连接导致
+
+func isBrokenPipe(err interface{}) bool {
+	e, ok := err.(error)
+	if !ok {
+		return false
+	}
+	var opErr *net.OpError
+	if !errors.As(e, &opErr) {
+		return false
+	}
+	if errors.Is(opErr, syscall.EPIPE) || errors.Is(opErr, syscall.ECONNRESET) {
+		return true
+	}
+	var sysErr *os.SyscallError
+	if errors.As(opErr, &sysErr) {
+		msg := strings.ToLower(sysErr.Error())
+		return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+	}
+	return false
+}
+
+// 输出请求行与请求头，敏感请求头的值被替换
+
+func dumpRequest(req *http.Request, redact []string) string {
+	r := *req
+	r.Header = req.Header.Clone()
+	for _, h := range redact {
+		if r.Header.Get(h) != "" {
+			r.Header.Set(h, "[REDACTED]")
+		}
+	}
+	dump, err := httputil.DumpRequest(&r, false)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(dump))
+}
@@ -0,0 +1,104 @@
+package GoMatrix
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// 请求ID在上下文中的key
+const RequestIDKey = "RequestID"
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+	maxRequestIDLength     = 128
+)
+
+type RequestIDConfig struct {
+	// 读取与回写请求ID的请求头，默认X-Request-ID
+	Header string
+	// 请求中没有ID时的生成方式，默认UUIDv4
+	Generator func() string
+}
+
+// 请求ID中间件，优先沿用上游传入的ID，保存到上下文并写入响应头
+
+func RequestID(config ...RequestIDConfig) HandlerFunc {
+	var conf RequestIDConfig
+	if len(config) > 0 {
+		conf = config[0]
+	}
+	if conf.Header == "" {
+		conf.Header = defaultRequestIDHeader
+	}
+	if conf.Generator == nil {
+		conf.Generator = UUIDv4
+	}
+	return func(c *Context) {
+		id := c.GetHeader(conf.Header)
+		if !validRequestID(id) {
+			id = conf.Generator()
+		}
+		c.Set(RequestIDKey, id)
+		c.SetHeader(conf.Header, id)
+		c.Next()
+	}
+}
+
+// 上游传入的ID会进入日志，只接受长度有限的可见ASCII字符
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// 随机生成的UUID（版本4）
+
+func UUIDv4() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID：48位毫秒时间戳加80位随机数，按时间有序
+
+func ULID() string {
+	var id [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(id[:6], ts[2:])
+	rand.Read(id[6:])
+
+	// 128位按5位一组编码为26个字符，首字符只占3位
+	var out [26]byte
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
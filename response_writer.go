@@ -0,0 +1,111 @@
+package GoMatrix
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+const noWritten = -1
+
+// 包装http.ResponseWriter，记录响应状态码和写入的字节数，同时透传Hijack、Flush、Push与ReadFrom
+
+type responseWriter struct {
+	http.ResponseWriter
+	size   int
+	status int
+	// 响应头写出前的回调，用于会话等需要最后写入响应头的场景
+	beforeWrite []func()
+}
+
+func (w *responseWriter) reset(writer http.ResponseWriter) {
+	w.ResponseWriter = writer
+	w.size = noWritten
+	w.status = http.StatusOK
+	w.beforeWrite = nil
+}
+
+func (w *responseWriter) before(fn func()) {
+	w.beforeWrite = append(w.beforeWrite, fn)
+}
+
+func (w *responseWriter) runBeforeWrite() {
+	hooks := w.beforeWrite
+	w.beforeWrite = nil
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	// 信息响应之后还有最终的状态码，不记录
+	if code >= 100 && code < http.StatusOK && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if !w.Written() {
+		w.runBeforeWrite()
+		w.status = code
+		w.size = 0
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(data []byte) (n int, err error) {
+	if !w.Written() {
+		w.runBeforeWrite()
+		w.size = 0
+	}
+	n, err = w.ResponseWriter.Write(data)
+	w.size += n
+	return
+}
+
+func (w *responseWriter) Written() bool {
+	return w.size != noWritten
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("GoMatrix: response does not implement http.Hijacker")
+	}
+	if !w.Written() {
+		w.size = 0
+	}
+	return hijacker.Hijack()
+}
+
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// HTTP/2服务端推送，底层不支持时返回http.ErrNotSupported
+
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := w.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// net/http的ResponseWriter通过ReadFrom使用sendfile，ServeFile与Static依赖它
+
+func (w *responseWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if !w.Written() {
+		w.runBeforeWrite()
+		w.size = 0
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		// 隐藏Write以外的方法，避免io.Copy再次调用ReadFrom
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.size += int(n)
+	return
+}
@@ -9,13 +9,13 @@ import (
 type router struct {
 	// 路由树
 	trees    methodTrees
-	handlers map[string]HandlerFunc
+	handlers map[string]HandlersChain
 }
 
 func newRouter() *router {
 	return &router{
 		trees:    make(methodTrees, 0, 9),
-		handlers: make(map[string]HandlerFunc),
+		handlers: make(map[string]HandlersChain),
 	}
 }
 
@@ -35,9 +35,10 @@ func parsePattern(pattern string) []string {
 	return parts
 }
 
-func (r *router) addRoute(method string, pattern string, handler HandlerFunc) {
+func (r *router) addRoute(method string, pattern string, handlers HandlersChain) {
 	assert1(pattern[0] == '/', "path must begin with '/'")
 	assert1(method != "", "HTTP method can not be empty")
+	assert1(len(handlers) > 0, "there must be at least one handler")
 
 	root := r.trees.get(method)
 	if root == nil {
@@ -48,7 +49,7 @@ func (r *router) addRoute(method string, pattern string, handler HandlerFunc) {
 	key := method + "-" + pattern
 	// 向树内插入路由
 	root.insert(pattern, parts, 0)
-	r.handlers[key] = handler
+	r.handlers[key] = handlers
 }
 
 func (r *router) getRoute(method string, path string) (*node, map[string]string) {
@@ -85,7 +86,8 @@ func (r *router) handle(c *Context) {
 	n, params := r.getRoute(c.Method, c.Path)
 	if n != nil {
 		c.Params = params
-		c.middlewares = append(c.middlewares, r.handlers[c.Method+"-"+n.pattern])
+		c.fullPath = n.pattern
+		c.middlewares = append(c.middlewares, r.handlers[c.Method+"-"+n.pattern]...)
 	} else {
 		c.middlewares = append(c.middlewares, func(c *Context) {
 			c.String(http.StatusNotFound, "404 NOT FOUND: %s\n", c.Path)
@@ -0,0 +1,141 @@
+package GoMatrix
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const cspNonceKey = "_cspNonce"
+
+type SecureConfig struct {
+	// Strict-Transport-Security的max-age，0表示不发送，只在HTTPS请求上发送
+	STSSeconds           int64
+	STSIncludeSubdomains bool
+	STSPreload           bool
+	// 以下字段为空时不发送对应的响应头
+	ContentTypeOptions      string
+	FrameOptions            string
+	ReferrerPolicy          string
+	PermissionsPolicy       string
+	CrossOriginOpenerPolicy string
+	// 其中的{nonce}会替换为每个请求随机生成的nonce，模板中使用{{cspNonce .ctx}}获取
+	ContentSecurityPolicy string
+	// 只上报不拦截，用于灰度新的策略
+	CSPReportOnly bool
+
+	// 将HTTP请求重定向到HTTPS
+	SSLRedirect bool
+	// 重定向使用的host，默认与请求相同
+	SSLHost string
+	// 位于TLS终止代理之后时，用于判断原始请求是否为HTTPS，例如{"X-Forwarded-Proto": "https"}
+	SSLProxyHeaders map[string]string
+}
+
+// 推荐的默认配置，可以在此基础上修改
+
+func DefaultSecureConfig() SecureConfig {
+	return SecureConfig{
+		STSSeconds:              31536000,
+		STSIncludeSubdomains:    true,
+		ContentTypeOptions:      "nosniff",
+		FrameOptions:            "DENY",
+		ReferrerPolicy:          "strict-origin-when-cross-origin",
+		PermissionsPolicy:       "camera=(), microphone=(), geolocation=()",
+		CrossOriginOpenerPolicy: "same-origin",
+		ContentSecurityPolicy:   "default-src 'self'; script-src 'self' 'nonce-{nonce}'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+	}
+}
+
+// 安全响应头中间件，不传配置时使用DefaultSecureConfig。
+// 分组上再次挂载时，分组的配置整体覆盖外层的配置，为空的字段会删除外层设置的响应头
+
+func Secure(config ...SecureConfig) HandlerFunc {
+	conf := DefaultSecureConfig()
+	if len(config) > 0 {
+		conf = config[0]
+	}
+	sts := ""
+	if conf.STSSeconds > 0 {
+		sts = "max-age=" + strconv.FormatInt(conf.STSSeconds, 10)
+		if conf.STSIncludeSubdomains {
+			sts += "; includeSubDomains"
+		}
+		if conf.STSPreload {
+			sts += "; preload"
+		}
+	}
+	cspHeader, otherCSPHeader := "Content-Security-Policy", "Content-Security-Policy-Report-Only"
+	if conf.CSPReportOnly {
+		cspHeader, otherCSPHeader = otherCSPHeader, cspHeader
+	}
+	return func(c *Context) {
+		secure := isSecureRequest(c, conf.SSLProxyHeaders)
+		if conf.SSLRedirect && !secure {
+			host := conf.SSLHost
+			if host == "" {
+				host = c.Host()
+			}
+			code := http.StatusPermanentRedirect
+			if c.Method == http.MethodGet || c.Method == http.MethodHead {
+				code = http.StatusMovedPermanently
+			}
+			c.SetHeader("Location", "https://"+host+c.Req.URL.RequestURI())
+			c.Status(code)
+			c.Abort()
+			return
+		}
+
+		header := c.Writer.Header()
+		setOrDelHeader(header, "X-Content-Type-Options", conf.ContentTypeOptions)
+		setOrDelHeader(header, "X-Frame-Options", conf.FrameOptions)
+		setOrDelHeader(header, "Referrer-Policy", conf.ReferrerPolicy)
+		setOrDelHeader(header, "Permissions-Policy", conf.PermissionsPolicy)
+		setOrDelHeader(header, "Cross-Origin-Opener-Policy", conf.CrossOriginOpenerPolicy)
+		if secure {
+			setOrDelHeader(header, "Strict-Transport-Security", sts)
+		}
+		csp := conf.ContentSecurityPolicy
+		if strings.Contains(csp, "{nonce}") {
+			csp = strings.ReplaceAll(csp, "{nonce}", CSPNonce(c))
+		}
+		header.Del(otherCSPHeader)
+		setOrDelHeader(header, cspHeader, csp)
+		c.Next()
+	}
+}
+
+func setOrDelHeader(header http.Header, key, value string) {
+	if value == "" {
+		header.Del(key)
+		return
+	}
+	header.Set(key, value)
+}
+
+func isSecureRequest(c *Context, proxyHeaders map[string]string) bool {
+	if c.Scheme() == "https" {
+		return true
+	}
+	for k, v := range proxyHeaders {
+		if strings.EqualFold(c.GetHeader(k), v) {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取当前请求的CSP nonce，同一个请求内保持不变；模板中为{{cspNonce .ctx}}
+
+func CSPNonce(c *Context) string {
+	if nonce := c.GetString(cspNonceKey); nonce != "" {
+		return nonce
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := base64.StdEncoding.EncodeToString(b)
+	c.Set(cspNonceKey, nonce)
+	return nonce
+}
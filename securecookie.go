@@ -0,0 +1,129 @@
+package GoMatrix
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	ErrCookieInvalid = errors.New("securecookie: invalid value")
+	ErrCookieExpired = errors.New("securecookie: expired")
+)
+
+// 一组cookie密钥：HashKey用于签名，BlockKey不为空时额外使用AES-GCM加密（16、24或32字节）
+
+type CookieKey struct {
+	HashKey  []byte
+	BlockKey []byte
+}
+
+type cookieCodec struct {
+	hashKey []byte
+	aead    cipher.AEAD
+}
+
+// 签名、加密cookie，第一组密钥用于编码，所有密钥都可以解码，轮换时把新密钥放在最前面
+
+type SecureCookie struct {
+	codecs []cookieCodec
+	// 大于0时拒绝签发时间早于该时长的值
+	MaxAge time.Duration
+}
+
+func NewSecureCookie(keys ...CookieKey) (*SecureCookie, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("securecookie: at least one key is required")
+	}
+	s := &SecureCookie{}
+	for _, k := range keys {
+		if len(k.HashKey) == 0 {
+			return nil, errors.New("securecookie: hash key must not be empty")
+		}
+		codec := cookieCodec{hashKey: k.HashKey}
+		if len(k.BlockKey) > 0 {
+			block, err := aes.NewCipher(k.BlockKey)
+			if err != nil {
+				return nil, err
+			}
+			if codec.aead, err = cipher.NewGCM(block); err != nil {
+				return nil, err
+			}
+		}
+		s.codecs = append(s.codecs, codec)
+	}
+	return s, nil
+}
+
+// 编码后的值绑定了cookie名称，不能挪用到其它cookie
+
+func (s *SecureCookie) Encode(name string, value []byte) (string, error) {
+	return s.encode(name, value, time.Now())
+}
+
+func (s *SecureCookie) encode(name string, value []byte, issued time.Time) (string, error) {
+	codec := s.codecs[0]
+	plain := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(plain, uint64(issued.Unix()))
+	plain = append(plain, value...)
+	payload := plain
+	if codec.aead != nil {
+		nonce := make([]byte, codec.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = codec.aead.Seal(nonce, nonce, plain, []byte(name))
+	}
+	payload = append(payload, codec.mac(name, payload)...)
+	return base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+func (s *SecureCookie) Decode(name, value string) ([]byte, error) {
+	return s.decode(name, value, s.MaxAge)
+}
+
+func (s *SecureCookie) decode(name, value string, maxAge time.Duration) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) < sha256.Size {
+		return nil, ErrCookieInvalid
+	}
+	payload, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	for _, codec := range s.codecs {
+		if !hmac.Equal(mac, codec.mac(name, payload)) {
+			continue
+		}
+		plain := payload
+		if codec.aead != nil {
+			n := codec.aead.NonceSize()
+			if len(payload) < n {
+				return nil, ErrCookieInvalid
+			}
+			if plain, err = codec.aead.Open(nil, payload[:n], payload[n:], []byte(name)); err != nil {
+				return nil, ErrCookieInvalid
+			}
+		}
+		if len(plain) < 8 {
+			return nil, ErrCookieInvalid
+		}
+		issued := time.Unix(int64(binary.BigEndian.Uint64(plain)), 0)
+		if maxAge > 0 && time.Since(issued) > maxAge {
+			return nil, ErrCookieExpired
+		}
+		return plain[8:], nil
+	}
+	return nil, ErrCookieInvalid
+}
+
+func (codec cookieCodec) mac(name string, payload []byte) []byte {
+	h := hmac.New(sha256.New, codec.hashKey)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,245 @@
+package GoMatrix
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testHashKey     = []byte("0123456789abcdef0123456789abcdef")
+	testBlockKey    = []byte("fedcba9876543210fedcba9876543210")
+	testOldHashKey  = []byte("old-hash-key-old-hash-key-old-ha")
+	testOldBlockKey = []byte("old-block-key-16")
+)
+
+func mustSecureCookie(t *testing.T, keys ...CookieKey) *SecureCookie {
+	t.Helper()
+	s, err := NewSecureCookie(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// 翻转base64解码后第i个字节（负数从末尾计算）
+func tamperCookie(t *testing.T, value string, i int) string {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i < 0 {
+		i += len(data)
+	}
+	data[i] ^= 0x01
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestSecureCookieKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []CookieKey
+		ok   bool
+	}{
+		{"no keys", nil, false},
+		{"empty hash key", []CookieKey{{}}, false},
+		{"hash only", []CookieKey{{HashKey: testHashKey}}, true},
+		{"aes-128", []CookieKey{{HashKey: testHashKey, BlockKey: testOldBlockKey}}, true},
+		{"aes-256", []CookieKey{{HashKey: testHashKey, BlockKey: testBlockKey}}, true},
+		{"invalid block key length", []CookieKey{{HashKey: testHashKey, BlockKey: []byte("short")}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSecureCookie(tt.keys...)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSecureCookieDecode(t *testing.T) {
+	signed := mustSecureCookie(t, CookieKey{HashKey: testHashKey})
+	encrypted := mustSecureCookie(t, CookieKey{HashKey: testHashKey, BlockKey: testBlockKey})
+	value := []byte("user=42")
+
+	tests := []struct {
+		name   string
+		codec  *SecureCookie
+		encode func(t *testing.T, s *SecureCookie) string
+		cookie string
+		maxAge time.Duration
+		err    error
+	}{
+		{
+			name:   "signed round trip",
+			codec:  signed,
+			encode: func(t *testing.T, s *SecureCookie) string { v, _ := s.Encode("sid", value); return v },
+		},
+		{
+			name:   "encrypted round trip",
+			codec:  encrypted,
+			encode: func(t *testing.T, s *SecureCookie) string { v, _ := s.Encode("sid", value); return v },
+		},
+		{
+			name:  "signed payload tampered",
+			codec: signed,
+			encode: func(t *testing.T, s *SecureCookie) string {
+				v, _ := s.Encode("sid", value)
+				return tamperCookie(t, v, 9)
+			},
+			err: ErrCookieInvalid,
+		},
+		{
+			name:  "signed mac tampered",
+			codec: signed,
+			encode: func(t *testing.T, s *SecureCookie) string {
+				v, _ := s.Encode("sid", value)
+				return tamperCookie(t, v, -1)
+			},
+			err: ErrCookieInvalid,
+		},
+		{
+			name:  "encrypted ciphertext tampered",
+			codec: encrypted,
+			encode: func(t *testing.T, s *SecureCookie) string {
+				v, _ := s.Encode("sid", value)
+				return tamperCookie(t, v, 14)
+			},
+			err: ErrCookieInvalid,
+		},
+		{
+			name:  "bound to cookie name",
+			codec: encrypted,
+			encode: func(t *testing.T, s *SecureCookie) string {
+				v, _ := s.Encode("other", value)
+				return v
+			},
+			err: ErrCookieInvalid,
+		},
+		{
+			name:  "signed by unknown key",
+			codec: signed,
+			encode: func(t *testing.T, s *SecureCookie) string {
+				v, _ := mustSecureCookie(t, CookieKey{HashKey: testOldHashKey}).Encode("sid", value)
+				return v
+			},
+			err: ErrCookieInvalid,
+		},
+		{name: "not base64", codec: signed, cookie: "!!!", err: ErrCookieInvalid},
+		{name: "truncated", codec: signed, cookie: "c2hvcnQ", err: ErrCookieInvalid},
+		{name: "empty", codec: signed, cookie: "", err: ErrCookieInvalid},
+		{
+			name:  "expired",
+			codec: encrypted,
+			encode: func(t *testing.T, s *SecureCookie) string {
+				v, _ := s.encode("sid", value, time.Now().Add(-2*time.Hour))
+				return v
+			},
+			maxAge: time.Hour,
+			err:    ErrCookieExpired,
+		},
+		{
+			name:  "within max age",
+			codec: signed,
+			encode: func(t *testing.T, s *SecureCookie) string {
+				v, _ := s.encode("sid", value, time.Now().Add(-30*time.Minute))
+				return v
+			},
+			maxAge: time.Hour,
+		},
+		{
+			name:  "old value without max age",
+			codec: signed,
+			encode: func(t *testing.T, s *SecureCookie) string {
+				v, _ := s.encode("sid", value, time.Now().Add(-365*24*time.Hour))
+				return v
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookie := tt.cookie
+			if tt.encode != nil {
+				cookie = tt.encode(t, tt.codec)
+			}
+			got, err := tt.codec.decode("sid", cookie, tt.maxAge)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && !bytes.Equal(got, value) {
+				t.Errorf("value = %q, want %q", got, value)
+			}
+		})
+	}
+}
+
+func TestSecureCookieMaxAgeField(t *testing.T) {
+	s := mustSecureCookie(t, CookieKey{HashKey: testHashKey})
+	s.MaxAge = time.Minute
+	v, err := s.encode("sid", []byte("x"), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Decode("sid", v); err != ErrCookieExpired {
+		t.Fatalf("err = %v, want %v", err, ErrCookieExpired)
+	}
+}
+
+func TestSecureCookieKeyRotation(t *testing.T) {
+	oldKey := CookieKey{HashKey: testOldHashKey, BlockKey: testOldBlockKey}
+	newKey := CookieKey{HashKey: testHashKey, BlockKey: testBlockKey}
+	old := mustSecureCookie(t, oldKey)
+	rotated := mustSecureCookie(t, newKey, oldKey)
+	current := mustSecureCookie(t, newKey)
+
+	fromOld, err := old.Encode("sid", []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromRotated, err := rotated.Encode("sid", []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		codec  *SecureCookie
+		cookie string
+		want   string
+		err    error
+	}{
+		{"rotated decodes old key", rotated, fromOld, "old", nil},
+		{"rotated decodes new key", rotated, fromRotated, "new", nil},
+		{"new values use first key", current, fromRotated, "new", nil},
+		{"old key alone rejects new values", old, fromRotated, "", ErrCookieInvalid},
+		{"retired key is rejected", current, fromOld, "", ErrCookieInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.codec.Decode("sid", tt.cookie)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("value = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecureCookieEncryptionHidesValue(t *testing.T) {
+	s := mustSecureCookie(t, CookieKey{HashKey: testHashKey, BlockKey: testBlockKey})
+	a, _ := s.Encode("sid", []byte("secret-value"))
+	b, _ := s.Encode("sid", []byte("secret-value"))
+	if a == b {
+		t.Error("encrypted values must use a fresh nonce")
+	}
+	data, _ := base64.RawURLEncoding.DecodeString(a)
+	if strings.Contains(string(data), "secret-value") {
+		t.Error("plaintext leaked into encrypted cookie")
+	}
+}
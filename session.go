@@ -0,0 +1,396 @@
+package GoMatrix
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 会话在上下文中的key
+const SessionKey = "Session"
+
+const (
+	defaultSessionName   = "session"
+	defaultSessionMaxAge = 24 * time.Hour
+	flashKey             = "_flash"
+	// 浏览器对单个cookie的大小限制
+	maxCookieSize = 4096
+)
+
+// 服务端会话存储，Load在会话不存在或已过期时返回nil, nil
+
+type SessionStore interface {
+	Load(id string) (map[string]interface{}, error)
+	Save(id string, values map[string]interface{}, maxAge time.Duration) error
+	Delete(id string) error
+}
+
+type SessionConfig struct {
+	// cookie名称，默认session
+	Name string
+	// 签名（可选加密）cookie的编解码器，必填
+	Codec *SecureCookie
+	// 服务端存储，为空时会话数据编码后直接保存在cookie中
+	Store SessionStore
+	// 会话有效期，默认24小时
+	MaxAge   time.Duration
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+type Session struct {
+	id      string
+	values  map[string]interface{}
+	isNew   bool
+	changed bool
+	// 重新生成ID后需要删除的旧ID
+	oldID     string
+	destroyed bool
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+func (s *Session) Get(key string) interface{} {
+	return s.values[key]
+}
+
+func (s *Session) Set(key string, value interface{}) {
+	s.values[key] = value
+	s.changed = true
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.values, key)
+	s.changed = true
+}
+
+// 清空会话数据
+
+func (s *Session) Clear() {
+	s.values = make(map[string]interface{})
+	s.changed = true
+}
+
+// 添加一次性消息，下次读取后即被删除
+
+func (s *Session) Flash(value interface{}) {
+	flashes, _ := s.values[flashKey].([]interface{})
+	s.values[flashKey] = append(flashes, value)
+	s.changed = true
+}
+
+func (s *Session) Flashes() []interface{} {
+	flashes, ok := s.values[flashKey].([]interface{})
+	if !ok {
+		return nil
+	}
+	delete(s.values, flashKey)
+	s.changed = true
+	return flashes
+}
+
+// 登录等权限变化时重新生成会话ID，防止会话固定攻击
+
+func (s *Session) Regenerate() {
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newSessionID()
+	s.changed = true
+}
+
+// 删除整个会话
+
+func (s *Session) Destroy() {
+	s.values = make(map[string]interface{})
+	s.destroyed = true
+	s.changed = true
+}
+
+// 获取当前请求的会话，需要先挂载Sessions中间件
+
+func (c *Context) Session() *Session {
+	return c.MustGet(SessionKey).(*Session)
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// 会话中间件，会话在响应头写出前自动保存
+
+func Sessions(conf SessionConfig) HandlerFunc {
+	assert1(conf.Codec != nil, "session codec can not be nil")
+	if conf.Name == "" {
+		conf.Name = defaultSessionName
+	}
+	if conf.MaxAge <= 0 {
+		conf.MaxAge = defaultSessionMaxAge
+	}
+	if conf.Path == "" {
+		conf.Path = "/"
+	}
+	return func(c *Context) {
+		s := loadSession(c, &conf)
+		c.Set(SessionKey, s)
+		saved := false
+		save := func() {
+			if saved {
+				return
+			}
+			saved = true
+			if err := saveSession(c, &conf, s); err != nil {
+				c.engine.logger.Errorf("save session failed: %v", err)
+			}
+		}
+		c.writermem.before(save)
+		c.Next()
+		// 处理函数没有写入响应时，响应头仍然可以修改
+		save()
+	}
+}
+
+func loadSession(c *Context, conf *SessionConfig) *Session {
+	s := &Session{values: make(map[string]interface{}), isNew: true}
+	cookie, err := c.Req.Cookie(conf.Name)
+	if err == nil {
+		if data, err := conf.Codec.decode(conf.Name, cookie.Value, conf.MaxAge); err == nil {
+			if conf.Store == nil {
+				var values map[string]interface{}
+				if json.Unmarshal(data, &values) == nil && values != nil {
+					s.values, s.isNew = values, false
+				}
+			} else if values, err := conf.Store.Load(string(data)); err == nil && values != nil {
+				s.id, s.values, s.isNew = string(data), values, false
+			} else if err != nil {
+				c.engine.logger.Errorf("load session failed: %v", err)
+			}
+		}
+	}
+	if s.isNew && conf.Store != nil {
+		s.id = newSessionID()
+	}
+	return s
+}
+
+func saveSession(c *Context, conf *SessionConfig, s *Session) error {
+	if !s.changed {
+		return nil
+	}
+	if conf.Store != nil && s.oldID != "" {
+		if err := conf.Store.Delete(s.oldID); err != nil {
+			return err
+		}
+	}
+	if s.destroyed {
+		if conf.Store != nil && !s.isNew {
+			if err := conf.Store.Delete(s.id); err != nil {
+				return err
+			}
+		}
+		writeSessionCookie(c, conf, "", -1)
+		return nil
+	}
+	var data []byte
+	if conf.Store == nil {
+		var err error
+		if data, err = json.Marshal(s.values); err != nil {
+			return err
+		}
+	} else {
+		if err := conf.Store.Save(s.id, s.values, conf.MaxAge); err != nil {
+			return err
+		}
+		data = []byte(s.id)
+	}
+	value, err := conf.Codec.Encode(conf.Name, data)
+	if err != nil {
+		return err
+	}
+	if len(conf.Name)+len(value) > maxCookieSize {
+		return errors.New("session: cookie exceeds 4096 bytes, use a server-side store")
+	}
+	writeSessionCookie(c, conf, value, int(conf.MaxAge/time.Second))
+	return nil
+}
+
+func writeSessionCookie(c *Context, conf *SessionConfig, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     conf.Name,
+		Value:    value,
+		Path:     conf.Path,
+		Domain:   conf.Domain,
+		MaxAge:   maxAge,
+		Secure:   conf.Secure,
+		HttpOnly: true,
+		SameSite: conf.SameSite,
+	})
+}
+
+// 内存存储，仅适用于单实例
+
+type memorySessionStore struct {
+	mu          sync.Mutex
+	sessions    map[string]memorySession
+	lastCleanup time.Time
+}
+
+type memorySession struct {
+	values  map[string]interface{}
+	expires time.Time
+}
+
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{sessions: make(map[string]memorySession), lastCleanup: time.Now()}
+}
+
+func (m *memorySessionStore) Load(id string) (map[string]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || time.Now().After(s.expires) {
+		delete(m.sessions, id)
+		return nil, nil
+	}
+	return copyValues(s.values), nil
+}
+
+func (m *memorySessionStore) Save(id string, values map[string]interface{}, maxAge time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.lastCleanup) > time.Minute {
+		m.lastCleanup = now
+		for k, s := range m.sessions {
+			if now.After(s.expires) {
+				delete(m.sessions, k)
+			}
+		}
+	}
+	m.sessions[id] = memorySession{values: copyValues(values), expires: now.Add(maxAge)}
+	return nil
+}
+
+func (m *memorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		cp[k] = v
+	}
+	return cp
+}
+
+// 文件存储，每个会话一个JSON文件
+
+type fileSessionStore struct {
+	dir string
+}
+
+type fileSession struct {
+	Values  map[string]interface{} `json:"values"`
+	Expires time.Time              `json:"expires"`
+}
+
+func NewFileSessionStore(dir string) (SessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileSessionStore{dir: dir}, nil
+}
+
+// 会话ID只包含base64url字符，防止路径穿越
+
+func (f *fileSessionStore) path(id string) (string, error) {
+	if id == "" {
+		return "", errors.New("session: empty id")
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_') {
+			return "", errors.New("session: invalid id")
+		}
+	}
+	return filepath.Join(f.dir, "session_"+id+".json"), nil
+}
+
+func (f *fileSessionStore) Load(id string) (map[string]interface{}, error) {
+	file, err := f.path(id)
+	if err != nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s fileSession
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if time.Now().After(s.Expires) {
+		os.Remove(file)
+		return nil, nil
+	}
+	return s.Values, nil
+}
+
+func (f *fileSessionStore) Save(id string, values map[string]interface{}, maxAge time.Duration) error {
+	file, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(fileSession{Values: values, Expires: time.Now().Add(maxAge)})
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免并发读到不完整的内容
+	tmp, err := os.CreateTemp(f.dir, "session_*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func (f *fileSessionStore) Delete(id string) error {
+	file, err := f.path(id)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
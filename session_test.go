@@ -0,0 +1,253 @@
+package GoMatrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSessionEngine(conf SessionConfig) *Engine {
+	engine := New()
+	engine.SetMode(ReleaseMode)
+	engine.Use(Sessions(conf))
+	engine.GET("/get", func(c *Context) {
+		s := c.Session()
+		user, _ := s.Get("user").(string)
+		c.String(http.StatusOK, "%s|%v|%s", user, s.IsNew(), s.ID())
+	})
+	engine.GET("/set", func(c *Context) {
+		c.Session().Set("user", c.Query("user"))
+		c.String(http.StatusOK, "%s", c.Session().ID())
+	})
+	engine.GET("/big", func(c *Context) {
+		c.Session().Set("user", strings.Repeat("x", maxCookieSize))
+		c.String(http.StatusOK, "ok")
+	})
+	engine.GET("/login", func(c *Context) {
+		c.Session().Regenerate()
+		c.Session().Set("user", "admin")
+		c.String(http.StatusOK, "%s", c.Session().ID())
+	})
+	engine.GET("/logout", func(c *Context) {
+		c.Session().Destroy()
+		c.String(http.StatusOK, "ok")
+	})
+	engine.GET("/flash", func(c *Context) {
+		c.Session().Flash("saved")
+		c.String(http.StatusOK, "ok")
+	})
+	engine.GET("/flashes", func(c *Context) {
+		c.JSON(http.StatusOK, c.Session().Flashes())
+	})
+	return engine
+}
+
+func sessionRequest(engine *Engine, path string, cookie *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == defaultSessionName {
+			return w, c
+		}
+	}
+	return w, nil
+}
+
+func TestSessionCookie(t *testing.T) {
+	oldKey := CookieKey{HashKey: testOldHashKey, BlockKey: testOldBlockKey}
+	newKey := CookieKey{HashKey: testHashKey, BlockKey: testBlockKey}
+	codec := mustSecureCookie(t, newKey, oldKey)
+	values, _ := json.Marshal(map[string]interface{}{"user": "alice"})
+
+	tests := []struct {
+		name   string
+		cookie func(t *testing.T) string
+		want   string
+	}{
+		{
+			name: "valid cookie",
+			cookie: func(t *testing.T) string {
+				v, _ := codec.Encode(defaultSessionName, values)
+				return v
+			},
+			want: "alice|false|",
+		},
+		{
+			name: "signed with rotated key",
+			cookie: func(t *testing.T) string {
+				v, _ := mustSecureCookie(t, oldKey).Encode(defaultSessionName, values)
+				return v
+			},
+			want: "alice|false|",
+		},
+		{
+			name: "tampered",
+			cookie: func(t *testing.T) string {
+				v, _ := codec.Encode(defaultSessionName, values)
+				return tamperCookie(t, v, 20)
+			},
+			want: "|true|",
+		},
+		{
+			name: "unknown key",
+			cookie: func(t *testing.T) string {
+				v, _ := mustSecureCookie(t, CookieKey{HashKey: []byte("another-hash-key")}).Encode(defaultSessionName, values)
+				return v
+			},
+			want: "|true|",
+		},
+		{
+			name: "expired",
+			cookie: func(t *testing.T) string {
+				v, _ := codec.encode(defaultSessionName, values, time.Now().Add(-2*time.Hour))
+				return v
+			},
+			want: "|true|",
+		},
+		{
+			name: "not json",
+			cookie: func(t *testing.T) string {
+				v, _ := codec.Encode(defaultSessionName, []byte("alice"))
+				return v
+			},
+			want: "|true|",
+		},
+	}
+	engine := newSessionEngine(SessionConfig{Codec: codec, MaxAge: time.Hour})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, set := sessionRequest(engine, "/get", &http.Cookie{Name: defaultSessionName, Value: tt.cookie(t)})
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+			// 只读的请求不需要重写cookie
+			if set != nil {
+				t.Errorf("unexpected Set-Cookie %q", set.Value)
+			}
+		})
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	codec := mustSecureCookie(t, CookieKey{HashKey: testHashKey, BlockKey: testBlockKey})
+	tests := []struct {
+		name  string
+		store SessionStore
+	}{
+		{"cookie store", nil},
+		{"memory store", NewMemorySessionStore()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := newSessionEngine(SessionConfig{Codec: codec, Store: tt.store})
+			_, cookie := sessionRequest(engine, "/set?user=bob", nil)
+			if cookie == nil {
+				t.Fatal("missing session cookie")
+			}
+			if !cookie.HttpOnly || cookie.Path != "/" || cookie.MaxAge != int(defaultSessionMaxAge/time.Second) {
+				t.Errorf("cookie attributes = %+v", cookie)
+			}
+			w, _ := sessionRequest(engine, "/get", cookie)
+			if got := w.Body.String(); !strings.HasPrefix(got, "bob|false|") {
+				t.Errorf("body = %q, want bob|false|...", got)
+			}
+
+			_, cookie = sessionRequest(engine, "/flash", cookie)
+			w, cookie = sessionRequest(engine, "/flashes", cookie)
+			if got := strings.TrimSpace(w.Body.String()); got != `["saved"]` {
+				t.Errorf("flashes = %s, want [\"saved\"]", got)
+			}
+			w, _ = sessionRequest(engine, "/flashes", cookie)
+			if got := strings.TrimSpace(w.Body.String()); got != "null" {
+				t.Errorf("flashes after read = %s, want null", got)
+			}
+		})
+	}
+}
+
+func TestSessionMaxCookieSize(t *testing.T) {
+	codec := mustSecureCookie(t, CookieKey{HashKey: testHashKey})
+	tests := []struct {
+		name   string
+		store  SessionStore
+		cookie bool
+	}{
+		{"cookie store rejects oversized value", nil, false},
+		{"memory store only keeps the id", NewMemorySessionStore(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := newSessionEngine(SessionConfig{Codec: codec, Store: tt.store})
+			w, cookie := sessionRequest(engine, "/big", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+			if (cookie != nil) != tt.cookie {
+				t.Fatalf("cookie set = %v, want %v", cookie != nil, tt.cookie)
+			}
+			if cookie != nil && len(cookie.Name)+len(cookie.Value) > maxCookieSize {
+				t.Errorf("cookie size = %d, want <= %d", len(cookie.Name)+len(cookie.Value), maxCookieSize)
+			}
+		})
+	}
+}
+
+func TestSessionRegenerate(t *testing.T) {
+	store := NewMemorySessionStore()
+	codec := mustSecureCookie(t, CookieKey{HashKey: testHashKey})
+	engine := newSessionEngine(SessionConfig{Codec: codec, Store: store})
+
+	w, cookie := sessionRequest(engine, "/set?user=guest", nil)
+	oldID := w.Body.String()
+	if values, _ := store.Load(oldID); values == nil {
+		t.Fatal("session was not saved")
+	}
+
+	w, cookie = sessionRequest(engine, "/login", cookie)
+	newID := w.Body.String()
+	if newID == oldID || newID == "" {
+		t.Fatalf("id not regenerated: %q -> %q", oldID, newID)
+	}
+	if values, _ := store.Load(oldID); values != nil {
+		t.Errorf("old session %q still in store", oldID)
+	}
+	// 旧cookie不能再使用
+	w, _ = sessionRequest(engine, "/get", &http.Cookie{Name: defaultSessionName, Value: mustEncode(t, codec, oldID)})
+	if got := w.Body.String(); !strings.HasPrefix(got, "|true|") {
+		t.Errorf("old id body = %q, want new session", got)
+	}
+	w, _ = sessionRequest(engine, "/get", cookie)
+	if got := w.Body.String(); got != "admin|false|"+newID {
+		t.Errorf("body = %q, want admin|false|%s", got, newID)
+	}
+
+	// 新会话直接登录时没有旧ID需要删除
+	w, _ = sessionRequest(engine, "/login", nil)
+	if values, _ := store.Load(w.Body.String()); values["user"] != "admin" {
+		t.Errorf("values = %v, want user admin", values)
+	}
+
+	_, cookie = sessionRequest(engine, "/logout", cookie)
+	if cookie == nil || cookie.MaxAge >= 0 || cookie.Value != "" {
+		t.Fatalf("logout cookie = %+v, want deletion", cookie)
+	}
+	if values, _ := store.Load(newID); values != nil {
+		t.Errorf("destroyed session %q still in store", newID)
+	}
+}
+
+func mustEncode(t *testing.T, codec *SecureCookie, value string) string {
+	t.Helper()
+	v, err := codec.Encode(defaultSessionName, []byte(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
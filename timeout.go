@@ -0,0 +1,191 @@
+package GoMatrix
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+type TimeoutConfig struct {
+	// 处理超时时间
+	Timeout time.Duration
+	// 超时后的状态码，默认503，也可以使用504
+	StatusCode int
+	// 自定义超时响应
+	Handler func(c *Context)
+}
+
+// 超时中间件：后续的中间件与处理函数在独立的goroutine中运行，使用带截止时间的context，
+// 响应先写入缓冲区，按时完成才会写给客户端。
+// 处理函数运行在上下文的副本上，超时返回后即使它仍在运行，也不会触碰已经放回池中的Context
+
+func Timeout(conf TimeoutConfig) HandlerFunc {
+	assert1(conf.Timeout > 0, "timeout must be positive")
+	if conf.StatusCode == 0 {
+		conf.StatusCode = http.StatusServiceUnavailable
+	}
+	return func(c *Context) {
+		ctx, cancel := context.WithTimeout(c.Req.Context(), conf.Timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		cp := c.shallowCopy(tw, c.Req.WithContext(ctx))
+		done := make(chan struct{})
+		panicChan := make(chan *timeoutPanic, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					if tw.timeout() {
+						cp.engine.logger.Errorf("panic after timeout: %v", p)
+						return
+					}
+					// 在处理函数的goroutine中记录调用栈，外层重新panic后栈中只剩下Timeout；
+					// http.ErrAbortHandler保持原值，net/http不会为它输出日志
+					if p == http.ErrAbortHandler {
+						panicChan <- &timeoutPanic{value: p}
+						return
+					}
+					panicChan <- &timeoutPanic{value: p, stack: debug.Stack()}
+					return
+				}
+				close(done)
+			}()
+			cp.Next()
+		}()
+
+		select {
+		case p := <-panicChan:
+			// 交给外层的Recovery处理，Recovery会输出其中的调用栈
+			if p.value == http.ErrAbortHandler {
+				panic(p.value)
+			}
+			panic(p)
+		case <-done:
+			c.Abort()
+			c.StatusCode = cp.StatusCode
+			c.Errors = cp.Errors
+			cp.mu.RLock()
+			for k, v := range cp.Keys {
+				c.Set(k, v)
+			}
+			cp.mu.RUnlock()
+			tw.writeTo(c.Writer)
+		case <-ctx.Done():
+			tw.timeout()
+			c.Abort()
+			if conf.Handler != nil {
+				conf.Handler(c)
+				return
+			}
+			c.String(conf.StatusCode, "%s\n", http.StatusText(conf.StatusCode))
+		}
+	}
+}
+
+// 处理函数goroutine中的panic，携带发生panic时的调用栈
+
+type timeoutPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// 没有Recovery时net/http会输出panic的值，同样带上调用栈
+func (p *timeoutPanic) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+// 拷贝一份上下文给其它goroutine使用，数据互不影响
+
+func (c *Context) shallowCopy(w http.ResponseWriter, req *http.Request) *Context {
+	cp := &Context{
+		Req:         req,
+		Path:        c.Path,
+		Method:      c.Method,
+		Params:      c.Params,
+		fullPath:    c.fullPath,
+		StatusCode:  c.StatusCode,
+		engine:      c.engine,
+		index:       c.index,
+		middlewares: c.middlewares,
+		Errors:      append([]error(nil), c.Errors...),
+	}
+	// 经过副本自己的writermem写入，后续中间件才能读到状态码与响应大小
+	cp.writermem.reset(w)
+	cp.Writer = &cp.writermem
+	c.mu.RLock()
+	if c.Keys != nil {
+		cp.Keys = make(map[string]interface{}, len(c.Keys))
+		for k, v := range c.Keys {
+			cp.Keys[k] = v
+		}
+	}
+	c.mu.RUnlock()
+	return cp
+}
+
+// 缓冲响应，超时之后的写入全部丢弃
+
+type timeoutWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	buf         bytes.Buffer
+	status      int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut || w.wroteHeader {
+		return
+	}
+	w.status = code
+	w.wroteHeader = true
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !w.wroteHeader {
+		w.status = http.StatusOK
+		w.wroteHeader = true
+	}
+	return w.buf.Write(data)
+}
+
+// 标记超时，返回是否已经超时
+
+func (w *timeoutWriter) timeout() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	timedOut := w.timedOut
+	w.timedOut = true
+	return timedOut
+}
+
+func (w *timeoutWriter) writeTo(dst http.ResponseWriter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	header := dst.Header()
+	for k, v := range w.header {
+		header[k] = v
+	}
+	if w.wroteHeader {
+		dst.WriteHeader(w.status)
+	}
+	if w.buf.Len() > 0 {
+		dst.Write(w.buf.Bytes())
+	}
+}
@@ -0,0 +1,215 @@
+package GoMatrix
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 证书与私钥文件
+
+type CertFile struct {
+	Crt string
+	Key string
+}
+
+type TLSOptions struct {
+	// 多个证书时根据SNI选择，都不匹配时使用第一个
+	Certificates []CertFile
+	// 校验客户端证书的CA文件，不为空时开启mTLS
+	ClientCAs []string
+	// 客户端证书的校验方式，配置了ClientCAs时默认为RequireAndVerifyClientCert
+	ClientAuth tls.ClientAuthType
+	// 最低版本，默认TLS 1.2
+	MinVersion uint16
+	// TLS 1.2的加密套件，默认只使用ECDHE+AEAD套件
+	CipherSuites []uint16
+	// 大于0时按该间隔检查证书与CA文件，文件变化后无需重启即可生效
+	ReloadInterval time.Duration
+}
+
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// 开启TLS，与SslNew只读取一次证书不同，这里的证书在握手时动态选择
+
+func (engine *Engine) UseTLS(opts TLSOptions) error {
+	if len(opts.Certificates) == 0 {
+		return errors.New("GoMatrix: at least one certificate is required")
+	}
+	store := &certStore{
+		engine:    engine,
+		certFiles: opts.Certificates,
+		caFiles:   opts.ClientCAs,
+		interval:  opts.ReloadInterval,
+	}
+	if err := store.load(); err != nil {
+		return err
+	}
+	config := &tls.Config{
+		MinVersion:     opts.MinVersion,
+		CipherSuites:   opts.CipherSuites,
+		ClientAuth:     opts.ClientAuth,
+		GetCertificate: store.getCertificate,
+		// GetConfigForClient返回的配置不会经过ServeTLS补充ALPN，这里直接声明
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if len(config.CipherSuites) == 0 {
+		config.CipherSuites = defaultCipherSuites
+	}
+	if len(opts.ClientCAs) > 0 {
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		config.ClientCAs = store.clientCAs
+		// CA池可能被热加载替换，每次握手取最新的
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			store.maybeReload()
+			cfg := config.Clone()
+			cfg.GetConfigForClient = nil
+			cfg.ClientCAs = store.currentClientCAs()
+			return cfg, nil
+		}
+	}
+	engine.isSsl = true
+	engine.tlsConfig = config
+	return nil
+}
+
+// mTLS下通过校验的客户端证书，没有时返回nil
+
+func (c *Context) ClientCertificate() *x509.Certificate {
+	if c.Req.TLS == nil || len(c.Req.TLS.VerifiedChains) == 0 || len(c.Req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return c.Req.TLS.VerifiedChains[0][0]
+}
+
+type certStore struct {
+	engine    *Engine
+	certFiles []CertFile
+	caFiles   []string
+	interval  time.Duration
+
+	mu        sync.RWMutex
+	certs     []*tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+
+	checkMu   sync.Mutex
+	lastCheck time.Time
+}
+
+func (s *certStore) load() error {
+	modTimes := make(map[string]time.Time)
+	certs := make([]*tls.Certificate, 0, len(s.certFiles))
+	for _, f := range s.certFiles {
+		cert, err := tls.LoadX509KeyPair(f.Crt, f.Key)
+		if err != nil {
+			return err
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+		certs = append(certs, &cert)
+		if err := statModTime(modTimes, f.Crt, f.Key); err != nil {
+			return err
+		}
+	}
+	var pool *x509.CertPool
+	if len(s.caFiles) > 0 {
+		pool = x509.NewCertPool()
+		for _, file := range s.caFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("GoMatrix: no certificates found in %s", file)
+			}
+		}
+		if err := statModTime(modTimes, s.caFiles...); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.certs = certs
+	s.clientCAs = pool
+	s.modTimes = modTimes
+	s.mu.Unlock()
+	return nil
+}
+
+func statModTime(modTimes map[string]time.Time, files ...string) error {
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = fi.ModTime()
+	}
+	return nil
+}
+
+// 距上次检查超过间隔时比较文件修改时间，有变化则重新加载，失败时继续使用旧证书
+
+func (s *certStore) maybeReload() {
+	if s.interval <= 0 {
+		return
+	}
+	s.checkMu.Lock()
+	defer s.checkMu.Unlock()
+	if time.Since(s.lastCheck) < s.interval {
+		return
+	}
+	s.lastCheck = time.Now()
+	s.mu.RLock()
+	changed := false
+	for file, modTime := range s.modTimes {
+		fi, err := os.Stat(file)
+		if err == nil && !fi.ModTime().Equal(modTime) {
+			changed = true
+			break
+		}
+	}
+	s.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err := s.load(); err != nil {
+		s.engine.logger.Errorf("reload certificates failed: %v", err)
+	}
+}
+
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.maybeReload()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.certs) > 1 {
+		for _, cert := range s.certs {
+			if hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return s.certs[0], nil
+}
+
+func (s *certStore) currentClientCAs() *x509.CertPool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clientCAs
+}
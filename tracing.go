@@ -0,0 +1,375 @@
+package GoMatrix
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (t TraceID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (s SpanID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// W3C Trace Context中传递的信息
+
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// traceparent请求头的值，例如00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// 解析traceparent，格式不合法时返回false
+
+func ParseTraceParent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	value = strings.TrimSpace(value)
+	// 更高的版本可能在末尾追加字段，只解析前55个字符
+	if len(value) < 55 || (len(value) > 55 && value[55] != '-') {
+		return sc, false
+	}
+	version := value[0:2]
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' || version == "ff" {
+		return sc, false
+	}
+	if version == "00" && len(value) != 55 {
+		return sc, false
+	}
+	if !isLowerHex(value[:55]) {
+		return sc, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(value[3:35])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(value[36:52])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(value[53:55])); err != nil {
+		return sc, false
+	}
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch != '-' && !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// 一次操作的记录，结束后交给Exporter导出
+
+type Span struct {
+	Name         string
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	// http状态码，0表示非http的span
+	Status     int
+	Attributes map[string]interface{}
+	Errors     []string
+
+	mu       sync.Mutex
+	ended    bool
+	exporter Exporter
+	logger   LevelLogger
+}
+
+// Span的方法都可以在nil上调用，未挂载Tracing时业务代码无需判断
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{})
+	}
+	s.Attributes[key] = value
+}
+
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Errors = append(s.Errors, err.Error())
+}
+
+// 结束span，采样的span会被导出，重复调用无效
+
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	if !s.SpanContext.Sampled || s.exporter == nil {
+		return
+	}
+	if err := s.exporter.Export(s); err != nil && s.logger != nil {
+		s.logger.Errorf("export span failed: %v", err)
+	}
+}
+
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+type spanContextKey struct{}
+
+// 从请求的context中获取当前span，没有时返回nil
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// 在当前span下创建子span，ctx中没有span时返回nil，需要调用Finish结束
+
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		Name:         name,
+		ParentSpanID: parent.SpanContext.SpanID,
+		Start:        time.Now(),
+		exporter:     parent.exporter,
+		logger:       parent.logger,
+	}
+	span.SpanContext = parent.SpanContext
+	span.SpanContext.SpanID = newSpanID()
+	return ContextWithSpan(ctx, span), span
+}
+
+// 把ctx中的trace信息写入请求头，用于调用下游服务
+
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	header.Set("traceparent", span.SpanContext.TraceParent())
+	if span.SpanContext.TraceState != "" {
+		header.Set("tracestate", span.SpanContext.TraceState)
+	}
+}
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return
+}
+
+// span导出器，可以对接Jaeger、Zipkin、OTLP等
+
+type Exporter interface {
+	Export(span *Span) error
+}
+
+// 每个span以一行JSON输出
+
+type stdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdoutExporter(w io.Writer) Exporter {
+	return &stdoutExporter{w: w}
+}
+
+func (e *stdoutExporter) Export(span *Span) error {
+	data, err := json.Marshal(struct {
+		Name         string                 `json:"name"`
+		TraceID      TraceID                `json:"trace_id"`
+		SpanID       SpanID                 `json:"span_id"`
+		ParentSpanID string                 `json:"parent_span_id,omitempty"`
+		Start        time.Time              `json:"start"`
+		DurationMs   float64                `json:"duration_ms"`
+		Status       int                    `json:"status,omitempty"`
+		Attributes   map[string]interface{} `json:"attributes,omitempty"`
+		Errors       []string               `json:"errors,omitempty"`
+	}{
+		Name:         span.Name,
+		TraceID:      span.SpanContext.TraceID,
+		SpanID:       span.SpanContext.SpanID,
+		ParentSpanID: parentSpanID(span),
+		Start:        span.Start,
+		DurationMs:   float64(span.Duration()) / float64(time.Millisecond),
+		Status:       span.Status,
+		Attributes:   span.Attributes,
+		Errors:       span.Errors,
+	})
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
+
+func parentSpanID(span *Span) string {
+	if !span.ParentSpanID.IsValid() {
+		return ""
+	}
+	return span.ParentSpanID.String()
+}
+
+// 保存在内存中的导出器，用于测试
+
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) Export(span *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+type TracingConfig struct {
+	Exporter Exporter
+	// 没有上游trace时是否采样，默认全部采样；有上游trace时沿用上游的采样标记
+	Sampler func(c *Context) bool
+	// 是否在响应头中返回traceparent与tracestate，便于排查问题
+	ResponseHeader bool
+}
+
+// 链路追踪中间件：沿用上游的traceparent与tracestate，为每个请求创建以路由模式命名的span，
+// span保存在c.Req.Context()中，可以通过SpanFromContext获取
+
+func Tracing(conf TracingConfig) HandlerFunc {
+	assert1(conf.Exporter != nil, "tracing exporter can not be nil")
+	return func(c *Context) {
+		span := &Span{
+			Start:    time.Now(),
+			exporter: conf.Exporter,
+			logger:   c.engine.logger,
+		}
+		if parent, ok := ParseTraceParent(c.GetHeader("traceparent")); ok {
+			span.ParentSpanID = parent.SpanID
+			span.SpanContext = parent
+			if state := c.GetHeader("tracestate"); len(state) <= 512 {
+				span.SpanContext.TraceState = state
+			}
+		} else {
+			span.SpanContext.TraceID = newTraceID()
+			span.SpanContext.Sampled = conf.Sampler == nil || conf.Sampler(c)
+		}
+		span.SpanContext.SpanID = newSpanID()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		// span名称会被后端用来聚合，非标准的方法与指标一样记为OTHER
+		method := metricsMethod(c.Method)
+		span.Name = method + " " + route
+		span.SetAttribute("http.method", method)
+		if method != c.Method {
+			span.SetAttribute("http.method_original", c.Method)
+		}
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", c.Req.URL.RequestURI())
+		span.SetAttribute("http.client_ip", c.ClientIP())
+
+		c.Req = c.Req.WithContext(ContextWithSpan(c.Req.Context(), span))
+		if conf.ResponseHeader {
+			InjectTraceContext(c.Req.Context(), c.Writer.Header())
+		}
+		defer span.Finish()
+		c.Next()
+
+		span.Status = c.writermem.status
+		span.SetAttribute("http.status_code", c.writermem.status)
+		for _, err := range c.Errors {
+			span.RecordError(err)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package GoMatrix
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// RFC 6455 WebSocket实现，通过路由组的WebSocket方法挂载，握手发生在路由处理函数中，
+// 因此分组上的中间件（鉴权等）会先于升级执行
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// 消息类型，与RFC 6455中的opcode一致
+
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// 关闭状态码
+
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseAbnormalClosure    = 1006
+	CloseInvalidPayloadData = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseInternalServerErr  = 1011
+)
+
+const (
+	defaultWebSocketReadLimit = 32 << 20
+	maxControlPayload         = 125
+	closeHandshakeTimeout     = 5 * time.Second
+)
+
+var (
+	ErrWebSocketMessageTooBig = errors.New("websocket: message too big")
+	ErrWebSocketClosed        = errors.New("websocket: connection closed")
+	errWebSocketBadHandshake  = errors.New("websocket: bad handshake")
+)
+
+// 对端发送的关闭帧
+
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Reason)
+}
+
+// WebSocket处理函数，可以通过Context读取路由参数和中间件写入的数据
+
+type WebSocketHandler func(c *Context, conn *WebSocketConn)
+
+type WebSocketConfig struct {
+	// 单条消息的最大字节数，超出后以1009关闭连接，默认32MB
+	ReadLimit int64
+	// 校验Origin，为空时要求Origin与Host一致（没有Origin头的非浏览器客户端放行）
+	CheckOrigin func(r *http.Request) bool
+	// 服务端支持的子协议，按优先级排列
+	Subprotocols []string
+	// 大于0时定时向客户端发送ping
+	PingInterval time.Duration
+	// 大于0时，超过该时间未收到任何帧则认为连接已失效
+	PongTimeout time.Duration
+}
+
+type WebSocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	config WebSocketConfig
+
+	subprotocol string
+
+	// 写锁，控制帧与数据帧可能来自不同goroutine
+	writeMu sync.Mutex
+
+	closeMu       sync.Mutex
+	closeSent     bool
+	closeReceived bool
+	done          chan struct{}
+
+	pongHandler func(data []byte)
+}
+
+// 在路由组上注册WebSocket路由
+
+func (group *RouterGroup) WebSocket(pattern string, handler WebSocketHandler, config ...WebSocketConfig) {
+	var cfg WebSocketConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.ReadLimit <= 0 {
+		cfg.ReadLimit = defaultWebSocketReadLimit
+	}
+	if cfg.CheckOrigin == nil {
+		cfg.CheckOrigin = checkSameOrigin
+	}
+	group.GET(pattern, func(c *Context) {
+		conn, err := upgradeWebSocket(c, cfg)
+		if err != nil {
+			return
+		}
+		defer conn.finish()
+		handler(c, conn)
+	})
+}
+
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func computeAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func selectSubprotocol(r *http.Request, supported []string) string {
+	if len(supported) == 0 {
+		return ""
+	}
+	var requested []string
+	for _, v := range r.Header["Sec-Websocket-Protocol"] {
+		for _, p := range strings.Split(v, ",") {
+			requested = append(requested, strings.TrimSpace(p))
+		}
+	}
+	for _, s := range supported {
+		for _, p := range requested {
+			if s == p {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// 握手校验失败时直接返回错误响应
+
+func upgradeWebSocket(c *Context, cfg WebSocketConfig) (*WebSocketConn, error) {
+	r := c.Req
+	if !headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		c.String(http.StatusBadRequest, "websocket: upgrade required\n")
+		return nil, errWebSocketBadHandshake
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		c.SetHeader("Sec-WebSocket-Version", "13")
+		c.String(http.StatusUpgradeRequired, "websocket: unsupported version\n")
+		return nil, errWebSocketBadHandshake
+	}
+	key := r.Header.Get("Sec-Websocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		c.String(http.StatusBadRequest, "websocket: invalid Sec-WebSocket-Key\n")
+		return nil, errWebSocketBadHandshake
+	}
+	if !cfg.CheckOrigin(r) {
+		c.String(http.StatusForbidden, "websocket: origin not allowed\n")
+		return nil, errWebSocketBadHandshake
+	}
+	hijacker, ok := c.Writer.(http.Hijacker)
+	if !ok {
+		c.String(http.StatusInternalServerError, "websocket: response does not implement http.Hijacker\n")
+		return nil, errWebSocketBadHandshake
+	}
+	subprotocol := selectSubprotocol(r, cfg.Subprotocols)
+	netConn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: " + computeAcceptKey(key) + "\r\n")
+	if subprotocol != "" {
+		b.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	b.WriteString("\r\n")
+	if _, err := netConn.Write([]byte(b.String())); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	c.StatusCode = http.StatusSwitchingProtocols
+	c.writermem.status = http.StatusSwitchingProtocols
+
+	conn := &WebSocketConn{
+		conn:        netConn,
+		reader:      brw.Reader,
+		config:      cfg,
+		subprotocol: subprotocol,
+		done:        make(chan struct{}),
+	}
+	if cfg.PongTimeout > 0 {
+		netConn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
+	}
+	if cfg.PingInterval > 0 {
+		go conn.pingLoop()
+	}
+	return conn, nil
+}
+
+// 协商出的子协议
+
+func (ws *WebSocketConn) Subprotocol() string {
+	return ws.subprotocol
+}
+
+func (ws *WebSocketConn) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+func (ws *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+func (ws *WebSocketConn) SetWriteDeadline(t time.Time) error {
+	return ws.conn.SetWriteDeadline(t)
+}
+
+// 收到pong时的回调
+
+func (ws *WebSocketConn) SetPongHandler(h func(data []byte)) {
+	ws.pongHandler = h
+}
+
+func (ws *WebSocketConn) pingLoop() {
+	ticker := time.NewTicker(ws.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ws.WritePing(nil); err != nil {
+				return
+			}
+		case <-ws.done:
+			return
+		}
+	}
+}
+
+// 读取一条完整的消息，ping会自动回复pong，收到关闭帧时回复关闭帧并返回*CloseError
+
+func (ws *WebSocketConn) ReadMessage() (messageType int, data []byte, err error) {
+	messageType = -1
+	for {
+		fin, opcode, payload, err := ws.readFrame(int64(len(data)))
+		if err != nil {
+			return -1, nil, err
+		}
+		switch opcode {
+		case PingMessage:
+			if err := ws.writeFrame(PongMessage, payload); err != nil {
+				return -1, nil, err
+			}
+			continue
+		case PongMessage:
+			if ws.pongHandler != nil {
+				ws.pongHandler(payload)
+			}
+			continue
+		case CloseMessage:
+			return -1, nil, ws.handleClose(payload)
+		case TextMessage, BinaryMessage:
+			if messageType != -1 {
+				return -1, nil, ws.failConnection(CloseProtocolError, "unexpected data frame")
+			}
+			messageType = int(opcode)
+		case continuationFrame:
+			if messageType == -1 {
+				return -1, nil, ws.failConnection(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return -1, nil, ws.failConnection(CloseProtocolError, "unknown opcode")
+		}
+		data = append(data, payload...)
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(data) {
+				return -1, nil, ws.failConnection(CloseInvalidPayloadData, "invalid utf-8")
+			}
+			return messageType, data, nil
+		}
+	}
+}
+
+func (ws *WebSocketConn) readFrame(received int64) (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(ws.reader, header[:]); err != nil {
+		return
+	}
+	if ws.config.PongTimeout > 0 {
+		ws.conn.SetReadDeadline(time.Now().Add(ws.config.PongTimeout))
+	}
+	fin = header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		err = ws.failConnection(CloseProtocolError, "reserved bits set")
+		return
+	}
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+	if !masked {
+		err = ws.failConnection(CloseProtocolError, "client frame not masked")
+		return
+	}
+	isControl := opcode >= CloseMessage
+	if isControl && (!fin || length > maxControlPayload) {
+		err = ws.failConnection(CloseProtocolError, "invalid control frame")
+		return
+	}
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.reader, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			err = ws.failConnection(CloseProtocolError, "invalid payload length")
+			return
+		}
+	}
+	// 不能先相加再比较，超大的length会溢出为负数
+	if !isControl && length > ws.config.ReadLimit-received {
+		ws.failConnection(CloseMessageTooBig, "message too big")
+		err = ErrWebSocketMessageTooBig
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// 收到关闭帧后按原状态码回复（如果我们还没发过），完成关闭握手
+
+func (ws *WebSocketConn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	if len(payload) == 1 {
+		ws.failConnection(CloseProtocolError, "invalid close payload")
+		return closeErr
+	}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !utf8.ValidString(closeErr.Reason) {
+			ws.failConnection(CloseInvalidPayloadData, "invalid utf-8")
+			return closeErr
+		}
+	}
+	ws.closeMu.Lock()
+	ws.closeReceived = true
+	ws.closeMu.Unlock()
+	code := closeErr.Code
+	if code == CloseNoStatusReceived {
+		code = CloseNormalClosure
+	}
+	ws.WriteClose(code, "")
+	return closeErr
+}
+
+// 协议错误时发送关闭帧并中断连接
+
+func (ws *WebSocketConn) failConnection(code int, reason string) error {
+	ws.WriteClose(code, reason)
+	ws.conn.Close()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func (ws *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	length := len(payload)
+	frame := make([]byte, 0, length+10)
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	frame = append(frame, payload...)
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+// 写入一条文本或二进制消息
+
+func (ws *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	ws.closeMu.Lock()
+	closed := ws.closeSent
+	ws.closeMu.Unlock()
+	if closed {
+		return ErrWebSocketClosed
+	}
+	return ws.writeFrame(byte(messageType), data)
+}
+
+func (ws *WebSocketConn) WriteText(text string) error {
+	return ws.WriteMessage(TextMessage, []byte(text))
+}
+
+func (ws *WebSocketConn) WritePing(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: control frame payload too large")
+	}
+	return ws.writeFrame(PingMessage, data)
+}
+
+// 发送关闭帧，之后不能再写入数据，继续调用ReadMessage直到返回*CloseError即可完成握手
+
+func (ws *WebSocketConn) WriteClose(code int, reason string) error {
+	ws.closeMu.Lock()
+	if ws.closeSent {
+		ws.closeMu.Unlock()
+		return nil
+	}
+	ws.closeSent = true
+	ws.closeMu.Unlock()
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	return ws.writeFrame(CloseMessage, payload)
+}
+
+// 处理函数返回后完成关闭握手并释放连接
+
+func (ws *WebSocketConn) finish() {
+	close(ws.done)
+	ws.WriteClose(CloseNormalClosure, "")
+	ws.closeMu.Lock()
+	received := ws.closeReceived
+	ws.closeMu.Unlock()
+	if !received {
+		ws.conn.SetReadDeadline(time.Now().Add(closeHandshakeTimeout))
+		for {
+			_, opcode, _, err := ws.readFrame(0)
+			if err != nil || opcode == CloseMessage {
+				break
+			}
+		}
+	}
+	ws.conn.Close()
+}
@@ -0,0 +1,316 @@
+package GoMatrix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWebSocketKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+type wsResult struct {
+	messageType int
+	data        []byte
+	err         error
+}
+
+// 启动一个回显服务，处理函数读到的结果通过results返回
+func newWebSocketServer(t *testing.T, config ...WebSocketConfig) (*httptest.Server, chan wsResult) {
+	t.Helper()
+	engine := New()
+	engine.SetMode(ReleaseMode)
+	results := make(chan wsResult, 16)
+	engine.WebSocket("/ws", func(c *Context, conn *WebSocketConn) {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			results <- wsResult{messageType, data, err}
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, data)
+		}
+	}, config...)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv, results
+}
+
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, srv *httptest.Server, header string) (*wsClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET /ws HTTP/1.1\r\nHost: " + strings.TrimPrefix(srv.URL, "http://") + "\r\n" + header + "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsClient{conn: conn, r: r}, resp
+}
+
+func upgradeHeader(extra string) string {
+	return "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " +
+		testWebSocketKey + "\r\n" + extra
+}
+
+func mustDialWebSocket(t *testing.T, srv *httptest.Server) *wsClient {
+	t.Helper()
+	client, resp := dialWebSocket(t, srv, upgradeHeader(""))
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	return client
+}
+
+// 客户端发送的帧必须带掩码
+func (c *wsClient) writeFrame(t *testing.T, fin bool, opcode byte, payload []byte, masked bool) {
+	t.Helper()
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, maskBit|126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		frame = append(append(frame, maskBit|127), ext[:]...)
+	}
+	data := append([]byte(nil), payload...)
+	if masked {
+		mask := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+		frame = append(frame, mask[:]...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	if _, err := c.conn.Write(append(frame, data...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *wsClient) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server frame must not be masked")
+	}
+	length := int(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.r, ext[:])
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func (c *wsClient) expectClose(t *testing.T, code int) {
+	t.Helper()
+	opcode, payload := c.readFrame(t)
+	if opcode != CloseMessage {
+		t.Fatalf("opcode = %d, want close", opcode)
+	}
+	if len(payload) < 2 {
+		t.Fatalf("close payload = %v, want status code", payload)
+	}
+	if got := int(binary.BigEndian.Uint16(payload)); got != code {
+		t.Fatalf("close code = %d, want %d", got, code)
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return append(payload, reason...)
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		status      int
+		subprotocol string
+	}{
+		{"valid", upgradeHeader(""), http.StatusSwitchingProtocols, ""},
+		{"subprotocol", upgradeHeader("Sec-WebSocket-Protocol: v1, chat\r\n"), http.StatusSwitchingProtocols, "chat"},
+		{"same origin", upgradeHeader("Origin: http://{host}\r\n"), http.StatusSwitchingProtocols, ""},
+		{"missing upgrade", "Connection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + testWebSocketKey + "\r\n", http.StatusBadRequest, ""},
+		{"unsupported version", strings.Replace(upgradeHeader(""), "Version: 13", "Version: 8", 1), http.StatusUpgradeRequired, ""},
+		{"invalid key", strings.Replace(upgradeHeader(""), testWebSocketKey, "short", 1), http.StatusBadRequest, ""},
+		{"cross origin", upgradeHeader("Origin: http://evil.example\r\n"), http.StatusForbidden, ""},
+	}
+	srv, _ := newWebSocketServer(t, WebSocketConfig{Subprotocols: []string{"chat"}})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := strings.Replace(tt.header, "{host}", strings.TrimPrefix(srv.URL, "http://"), 1)
+			_, resp := dialWebSocket(t, srv, header)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusSwitchingProtocols {
+				return
+			}
+			// RFC 6455 1.3中的示例
+			if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+				t.Errorf("Sec-WebSocket-Accept = %q", accept)
+			}
+			if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != tt.subprotocol {
+				t.Errorf("Sec-WebSocket-Protocol = %q, want %q", got, tt.subprotocol)
+			}
+		})
+	}
+}
+
+func TestWebSocketMaskingAndEcho(t *testing.T) {
+	srv, results := newWebSocketServer(t)
+	client := mustDialWebSocket(t, srv)
+	payload := []byte(strings.Repeat("masked payload ", 20))
+	client.writeFrame(t, true, BinaryMessage, payload, true)
+	if r := <-results; r.err != nil || r.messageType != BinaryMessage || string(r.data) != string(payload) {
+		t.Fatalf("ReadMessage() = %d, %q, %v", r.messageType, r.data, r.err)
+	}
+	if opcode, data := client.readFrame(t); opcode != BinaryMessage || string(data) != string(payload) {
+		t.Fatalf("echo = %d, %q", opcode, data)
+	}
+}
+
+func TestWebSocketFragmentation(t *testing.T) {
+	srv, results := newWebSocketServer(t)
+	client := mustDialWebSocket(t, srv)
+	client.writeFrame(t, false, TextMessage, []byte("hel"), true)
+	// 分片之间可以插入控制帧
+	client.writeFrame(t, true, PingMessage, []byte("p"), true)
+	client.writeFrame(t, false, continuationFrame, []byte("lo "), true)
+	client.writeFrame(t, true, continuationFrame, []byte("world"), true)
+	if opcode, data := client.readFrame(t); opcode != PongMessage || string(data) != "p" {
+		t.Fatalf("pong = %d, %q", opcode, data)
+	}
+	if r := <-results; r.err != nil || r.messageType != TextMessage || string(r.data) != "hello world" {
+		t.Fatalf("ReadMessage() = %d, %q, %v", r.messageType, r.data, r.err)
+	}
+	if opcode, data := client.readFrame(t); opcode != TextMessage || string(data) != "hello world" {
+		t.Fatalf("echo = %d, %q", opcode, data)
+	}
+}
+
+func TestWebSocketReadLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames func(t *testing.T, c *wsClient)
+	}{
+		{"single frame", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, true, BinaryMessage, make([]byte, 17), true)
+		}},
+		{"fragments", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, false, BinaryMessage, make([]byte, 10), true)
+			c.writeFrame(t, true, continuationFrame, make([]byte, 10), true)
+		}},
+		{"length overflow", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, false, TextMessage, []byte("a"), true)
+			// 只发送帧头，声明的长度加上已收到的字节会溢出int64
+			frame := []byte{0x80 | continuationFrame, 0x80 | 127, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4}
+			if _, err := c.conn.Write(frame); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, results := newWebSocketServer(t, WebSocketConfig{ReadLimit: 16})
+			client := mustDialWebSocket(t, srv)
+			tt.frames(t, client)
+			client.expectClose(t, CloseMessageTooBig)
+			if r := <-results; !errors.Is(r.err, ErrWebSocketMessageTooBig) {
+				t.Fatalf("ReadMessage() error = %v, want ErrWebSocketMessageTooBig", r.err)
+			}
+		})
+	}
+}
+
+func TestWebSocketCloseCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(t *testing.T, c *wsClient)
+		code int
+	}{
+		{"normal closure", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, true, CloseMessage, closePayload(CloseNormalClosure, "bye"), true)
+		}, CloseNormalClosure},
+		{"going away is echoed", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, true, CloseMessage, closePayload(CloseGoingAway, ""), true)
+		}, CloseGoingAway},
+		{"empty close", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, true, CloseMessage, nil, true)
+		}, CloseNormalClosure},
+		{"unmasked frame", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, true, TextMessage, []byte("hi"), false)
+		}, CloseProtocolError},
+		{"reserved bits", func(t *testing.T, c *wsClient) {
+			c.conn.Write([]byte{0x80 | 0x40 | TextMessage, 0x80, 1, 2, 3, 4})
+		}, CloseProtocolError},
+		{"unknown opcode", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, true, 3, []byte("x"), true)
+		}, CloseProtocolError},
+		{"fragmented control frame", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, false, PingMessage, []byte("x"), true)
+		}, CloseProtocolError},
+		{"unexpected continuation", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, true, continuationFrame, []byte("x"), true)
+		}, CloseProtocolError},
+		{"invalid utf-8", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, true, TextMessage, []byte{0xff, 0xfe}, true)
+		}, CloseInvalidPayloadData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, results := newWebSocketServer(t)
+			client := mustDialWebSocket(t, srv)
+			tt.send(t, client)
+			client.expectClose(t, tt.code)
+			r := <-results
+			var closeErr *CloseError
+			if !errors.As(r.err, &closeErr) {
+				t.Fatalf("ReadMessage() error = %v, want *CloseError", r.err)
+			}
+		})
+	}
+}